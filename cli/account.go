@@ -0,0 +1,206 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/dustin/go-humanize"
+	"pget/premiumize"
+	"time"
+)
+
+const accountBucket = "account"
+
+// accountDayFormat keys the account samples, one per day.
+const accountDayFormat = "2006-01-02"
+const accountCheckInterval = 15 * time.Minute
+const defaultPauseAt = 0.95
+const defaultWarnExpiry = 7 * 24 * time.Hour
+
+// AccountConfig holds the limits watch keeps an eye on. premiumize does not
+// report the space limit, it has to be configured to pause uploads on space.
+type AccountConfig struct {
+	SpaceLimit string `mapstructure:"space_limit"`
+	// PauseAt is the used share of the space limit or of the fair use points
+	// at which uploads are paused, defaults to 0.95.
+	PauseAt float64 `mapstructure:"pause_at"`
+	// WarnExpiry is how long before the premium expires watch starts warning,
+	// defaults to 7 days.
+	WarnExpiry time.Duration `mapstructure:"warn_expiry"`
+}
+
+// accountSample is the account state recorded by watch over time.
+type accountSample struct {
+	Time         time.Time `json:"time"`
+	PremiumUntil int64     `json:"premium_until"`
+	LimitUsed    float64   `json:"limit_used"`
+	SpaceUsed    float64   `json:"space_used"`
+}
+
+func (c *Cli) SetAccount(config AccountConfig) {
+	if config.PauseAt == 0 {
+		config.PauseAt = defaultPauseAt
+	}
+	if config.WarnExpiry == 0 {
+		config.WarnExpiry = defaultWarnExpiry
+	}
+	c.accountConfig = config
+}
+
+func (c *Cli) Account() {
+	info, err := c.premiumize.AccountInfo()
+	if err != nil {
+		fmt.Printf("Unable to retrieve account info: %s\n", err.Error())
+		return
+	}
+
+	premiumUntil := time.Unix(info.PremiumUntil, 0)
+	fmt.Printf("Customer:      %s\n", info.CustomerID)
+	if info.PremiumUntil == 0 {
+		fmt.Println("Premium until: no premium")
+	} else {
+		fmt.Printf("Premium until: %s (%s)\n", premiumUntil.Format("2006-01-02"), humanize.Time(premiumUntil))
+	}
+	fmt.Printf("Space used:    %s", humanize.Bytes(uint64(info.SpaceUsed)))
+	if limit := c.spaceLimit(); limit > 0 {
+		fmt.Printf(" of %s (%.0f%%)", humanize.Bytes(limit), 100*info.SpaceUsed/float64(limit))
+	}
+	fmt.Println()
+	fmt.Printf("Fair use:      %.0f%% used\n", 100*info.LimitUsed)
+}
+
+func (c *Cli) spaceLimit() uint64 {
+	limit, err := parseStopAfter(c.accountConfig.SpaceLimit)
+	if err != nil {
+		fmt.Printf("Unable to parse %s. Error: %s\n", c.accountConfig.SpaceLimit, err.Error())
+	}
+	return limit
+}
+
+// WatchAccount records the account state until the context is done. Uploads
+// are paused while the space or the fair use points are nearly used up and a
+// warning is printed once a day when the premium is about to expire.
+func (c *Cli) WatchAccount(ctx context.Context) {
+	var warned time.Time
+	for {
+		info, err := c.premiumize.AccountInfo()
+		if err != nil {
+			fmt.Printf("Unable to retrieve account info: %s\n", err.Error())
+		} else {
+			c.recordAccount(info)
+			c.pauseUploads(info)
+
+			premiumUntil := time.Unix(info.PremiumUntil, 0)
+			if time.Until(premiumUntil) < c.accountConfig.WarnExpiry && time.Since(warned) > 24*time.Hour {
+				if info.PremiumUntil == 0 {
+					fmt.Println("Warning: the account has no premium")
+				} else {
+					fmt.Printf("Warning: premium expires %s\n", humanize.Time(premiumUntil))
+				}
+				warned = time.Now()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(accountCheckInterval):
+		}
+	}
+}
+
+// recordAccount keeps the latest sample of every day, for a year.
+func (c *Cli) recordAccount(info premiumize.AccountInfo) {
+	sample := accountSample{
+		Time:         time.Now(),
+		PremiumUntil: info.PremiumUntil,
+		LimitUsed:    info.LimitUsed,
+		SpaceUsed:    info.SpaceUsed,
+	}
+
+	err := c.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(accountBucket))
+		if err != nil {
+			return err
+		}
+
+		expired := []byte(sample.Time.AddDate(-1, 0, 0).Format(accountDayFormat))
+		cursor := bucket.Cursor()
+		for key, _ := cursor.First(); key != nil && string(key) < string(expired); key, _ = cursor.First() {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return putJSONTx(bucket, sample.Time.Format(accountDayFormat), sample)
+	})
+	if err != nil {
+		fmt.Printf("Could not record account info: %s\n", err.Error())
+	}
+}
+
+func (c *Cli) pauseUploads(info premiumize.AccountInfo) {
+	reason := ""
+	if limit := c.spaceLimit(); limit > 0 && info.SpaceUsed >= c.accountConfig.PauseAt*float64(limit) {
+		reason = fmt.Sprintf("%s of %s space used", humanize.Bytes(uint64(info.SpaceUsed)), humanize.Bytes(limit))
+	} else if info.LimitUsed >= c.accountConfig.PauseAt {
+		reason = fmt.Sprintf("%.0f%% of the fair use points used", 100*info.LimitUsed)
+	}
+
+	c.accountMutex.Lock()
+	defer c.accountMutex.Unlock()
+
+	if reason != "" && !c.uploadsPaused {
+		fmt.Printf("Pausing uploads, %s\n", reason)
+	} else if reason == "" && c.uploadsPaused {
+		fmt.Println("Resuming uploads")
+	}
+	c.uploadsPaused = reason != ""
+}
+
+func (c *Cli) areUploadsPaused() bool {
+	c.accountMutex.Lock()
+	defer c.accountMutex.Unlock()
+	return c.uploadsPaused
+}
+
+// Stats prints the recorded account state of the last days, one line per day.
+func (c *Cli) Stats(days int) {
+	var samples []accountSample
+	err := c.forEachJSON(accountBucket, func() interface{} { return &accountSample{} }, func(key string, value interface{}) {
+		samples = append(samples, *value.(*accountSample))
+	})
+	if err != nil {
+		fmt.Printf("Could not read account history: %s\n", err.Error())
+		return
+	}
+
+	// Keys are sorted by time, the last sample of a day wins
+	daily := make(map[string]accountSample)
+	var order []string
+	since := time.Now().AddDate(0, 0, -days)
+	for _, sample := range samples {
+		if sample.Time.Before(since) {
+			continue
+		}
+		day := sample.Time.Local().Format(accountDayFormat)
+		if _, ok := daily[day]; !ok {
+			order = append(order, day)
+		}
+		daily[day] = sample
+	}
+
+	if len(order) == 0 {
+		fmt.Println("No account history recorded yet, it is recorded while watch is running")
+		return
+	}
+
+	for _, day := range order {
+		sample := daily[day]
+		premiumUntil := "-"
+		if sample.PremiumUntil != 0 {
+			premiumUntil = time.Unix(sample.PremiumUntil, 0).Format("2006-01-02")
+		}
+		fmt.Printf("%s  space %10s  fair use %3.0f%%  premium until %s\n", day, humanize.Bytes(uint64(sample.SpaceUsed)), 100*sample.LimitUsed, premiumUntil)
+	}
+}
@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"strconv"
+	"time"
+)
+
+const volumeBucket = "volume"
+
+// volumeKeyFormat sorts lexically, which the rolling windows rely on.
+const volumeKeyFormat = "2006-01-02T15:04:05.000000000Z"
+
+var errBudgetExceeded = fmt.Errorf("Download budget used up")
+
+// BudgetConfig holds the download volume allowed within the last day, week
+// and month (30 days), e.g. 50gb. Empty values are unlimited.
+type BudgetConfig struct {
+	Day   string `mapstructure:"day"`
+	Week  string `mapstructure:"week"`
+	Month string `mapstructure:"month"`
+}
+
+type volumeBudget struct {
+	name   string
+	period time.Duration
+	limit  uint64
+}
+
+// SetBudget parses the download volume budgets.
+func (c *Cli) SetBudget(config BudgetConfig) error {
+	var budgets []volumeBudget
+	for _, budget := range []struct {
+		name   string
+		period time.Duration
+		limit  string
+	}{
+		{"day", 24 * time.Hour, config.Day},
+		{"week", 7 * 24 * time.Hour, config.Week},
+		{"month", 30 * 24 * time.Hour, config.Month},
+	} {
+		limit, err := parseStopAfter(budget.limit)
+		if err != nil {
+			return fmt.Errorf("Invalid %s budget %s: %s", budget.name, budget.limit, err.Error())
+		}
+		if limit > 0 {
+			budgets = append(budgets, volumeBudget{name: budget.name, period: budget.period, limit: limit})
+		}
+	}
+
+	c.budgets = budgets
+	return nil
+}
+
+// recordVolume adds downloaded bytes to the volume history. History older than
+// the longest budget period is dropped.
+func (c *Cli) recordVolume(bytes uint64) {
+	if bytes == 0 {
+		return
+	}
+
+	err := c.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(volumeBucket))
+		if err != nil {
+			return err
+		}
+
+		expired := []byte(time.Now().UTC().AddDate(0, 0, -31).Format(volumeKeyFormat))
+		cursor := bucket.Cursor()
+		for key, _ := cursor.First(); key != nil && string(key) < string(expired); key, _ = cursor.First() {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		key := time.Now().UTC().Format(volumeKeyFormat)
+		return bucket.Put([]byte(key), []byte(strconv.FormatUint(bytes, 10)))
+	})
+	if err != nil {
+		fmt.Printf("Could not record download volume: %s\n", err.Error())
+	}
+}
+
+// volumeSince returns the bytes downloaded since the given time.
+func (c *Cli) volumeSince(since time.Time) (uint64, error) {
+	var total uint64
+	err := c.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(volumeBucket))
+		if bucket == nil {
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+		for key, value := cursor.Seek([]byte(since.UTC().Format(volumeKeyFormat))); key != nil; key, value = cursor.Next() {
+			bytes, err := strconv.ParseUint(string(value), 10, 64)
+			if err != nil {
+				return err
+			}
+			total += bytes
+		}
+		return nil
+	})
+	return total, err
+}
+
+// remainingBudget returns how many bytes may still be downloaded according to
+// the tightest budget, and false if there are no budgets.
+func (c *Cli) remainingBudget() (uint64, bool) {
+	if len(c.budgets) == 0 {
+		return 0, false
+	}
+
+	var remaining uint64
+	for i, budget := range c.budgets {
+		used, err := c.volumeSince(time.Now().Add(-budget.period))
+		if err != nil {
+			fmt.Printf("Could not read download volume: %s\n", err.Error())
+			return 0, true
+		}
+
+		left := uint64(0)
+		if used < budget.limit {
+			left = budget.limit - used
+		}
+		if i == 0 || left < remaining {
+			remaining = left
+		}
+	}
+	return remaining, true
+}
+
+// budgetAllows reports whether a download of the given bytes may start, on top
+// of bytes planned but not downloaded yet. It has to fit into what is left of
+// every budget. A download larger than a budget would never fit into it, so it
+// may start once nothing has been downloaded within that budget's period.
+func (c *Cli) budgetAllows(bytes uint64, planned uint64) bool {
+	for _, budget := range c.budgets {
+		used, err := c.volumeSince(time.Now().Add(-budget.period))
+		if err != nil {
+			fmt.Printf("Could not read download volume: %s\n", err.Error())
+			return false
+		}
+		used += planned
+
+		if bytes > budget.limit {
+			if used > 0 {
+				return false
+			}
+			continue
+		}
+		if used+bytes > budget.limit {
+			return false
+		}
+	}
+	return true
+}
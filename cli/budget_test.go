@@ -0,0 +1,213 @@
+package cli
+
+import (
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func storeVolume(t *testing.T, c *Cli, at time.Time, bytes uint64) {
+	err := c.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(volumeBucket))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(at.UTC().Format(volumeKeyFormat)), []byte(strconv.FormatUint(bytes, 10)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetBudget(t *testing.T) {
+	tests := []struct {
+		config  BudgetConfig
+		budgets []volumeBudget
+		wantErr bool
+	}{
+		{config: BudgetConfig{}},
+		{config: BudgetConfig{Day: "1kb"}, budgets: []volumeBudget{{"day", 24 * time.Hour, 1000}}},
+		{config: BudgetConfig{Week: "2 KiB", Month: "3000"}, budgets: []volumeBudget{{"week", 7 * 24 * time.Hour, 2048}, {"month", 30 * 24 * time.Hour, 3000}}},
+		{config: BudgetConfig{Day: "lots"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		c := New(nil)
+		err := c.SetBudget(test.config)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("SetBudget(%+v) succeeded, want an error", test.config)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("SetBudget(%+v) failed: %s", test.config, err)
+			continue
+		}
+		if len(c.budgets) != len(test.budgets) {
+			t.Errorf("SetBudget(%+v) = %+v, want %+v", test.config, c.budgets, test.budgets)
+			continue
+		}
+		for i := range test.budgets {
+			if c.budgets[i] != test.budgets[i] {
+				t.Errorf("SetBudget(%+v) = %+v, want %+v", test.config, c.budgets, test.budgets)
+				break
+			}
+		}
+	}
+}
+
+func TestRemainingBudget(t *testing.T) {
+	defer inTempDir(t)()
+
+	c := New(nil)
+	now := time.Now()
+	storeVolume(t, c, now.Add(-time.Hour), 100)
+	storeVolume(t, c, now.Add(-23*time.Hour), 200)
+	storeVolume(t, c, now.Add(-25*time.Hour), 400)
+	storeVolume(t, c, now.Add(-6*24*time.Hour), 800)
+	storeVolume(t, c, now.Add(-8*24*time.Hour), 1600)
+	storeVolume(t, c, now.Add(-29*24*time.Hour), 3200)
+	storeVolume(t, c, now.Add(-31*24*time.Hour), 6400)
+
+	tests := []struct {
+		config    BudgetConfig
+		remaining uint64
+		limited   bool
+	}{
+		{config: BudgetConfig{}},
+		{config: BudgetConfig{Day: "1000"}, remaining: 700, limited: true},
+		{config: BudgetConfig{Day: "300"}, remaining: 0, limited: true},
+		{config: BudgetConfig{Day: "200"}, remaining: 0, limited: true},
+		{config: BudgetConfig{Week: "2000"}, remaining: 500, limited: true},
+		{config: BudgetConfig{Month: "10000"}, remaining: 3700, limited: true},
+		{config: BudgetConfig{Day: "1000", Week: "1600"}, remaining: 100, limited: true},
+		{config: BudgetConfig{Day: "350", Week: "10000", Month: "100000"}, remaining: 50, limited: true},
+	}
+
+	for _, test := range tests {
+		if err := c.SetBudget(test.config); err != nil {
+			t.Fatalf("SetBudget(%+v) failed: %s", test.config, err)
+		}
+		remaining, limited := c.remainingBudget()
+		if remaining != test.remaining || limited != test.limited {
+			t.Errorf("remainingBudget() with %+v = %d, %v, want %d, %v", test.config, remaining, limited, test.remaining, test.limited)
+		}
+	}
+}
+
+func TestRecordVolume(t *testing.T) {
+	defer inTempDir(t)()
+
+	c := New(nil)
+	storeVolume(t, c, time.Now().AddDate(0, 0, -40), 1000)
+	storeVolume(t, c, time.Now().AddDate(0, 0, -20), 100)
+	c.recordVolume(10)
+	c.recordVolume(0)
+
+	tests := []struct {
+		since time.Time
+		want  uint64
+	}{
+		{time.Now().AddDate(0, 0, -50), 110},
+		{time.Now().AddDate(0, 0, -25), 110},
+		{time.Now().Add(-time.Minute), 10},
+		{time.Now().Add(time.Minute), 0},
+	}
+
+	for _, test := range tests {
+		got, err := c.volumeSince(test.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("volumeSince(%s) = %d, want %d", test.since, got, test.want)
+		}
+	}
+}
+
+func TestRemainingBudgetWithoutDatabase(t *testing.T) {
+	defer inTempDir(t)()
+
+	c := New(nil)
+	if err := c.SetBudget(BudgetConfig{Day: "1000"}); err != nil {
+		t.Fatal(err)
+	}
+	if remaining, limited := c.remainingBudget(); remaining != 1000 || !limited {
+		t.Errorf("remainingBudget() = %d, %v, want 1000, true", remaining, limited)
+	}
+	if _, err := os.Stat(boltDBFile); !os.IsNotExist(err) {
+		t.Errorf("remainingBudget() created %s", boltDBFile)
+	}
+}
+
+func TestBudgetAllows(t *testing.T) {
+	defer inTempDir(t)()
+
+	c := New(nil)
+	if !c.budgetAllows(1<<40, 0) {
+		t.Errorf("budgetAllows() without budgets = false, want true")
+	}
+
+	if err := c.SetBudget(BudgetConfig{Day: "1000", Week: "5000"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		bytes   uint64
+		planned uint64
+		want    bool
+	}{
+		{bytes: 1000, want: true},
+		{bytes: 4000, want: true},
+		{bytes: 900, planned: 200, want: false},
+		{bytes: 800, planned: 200, want: true},
+		{bytes: 6000, want: true},
+		{bytes: 6000, planned: 1, want: false},
+	}
+	for _, test := range tests {
+		if got := c.budgetAllows(test.bytes, test.planned); got != test.want {
+			t.Errorf("budgetAllows(%d, %d) without volume = %v, want %v", test.bytes, test.planned, got, test.want)
+		}
+	}
+
+	// Volume from two days ago only counts against the week
+	storeVolume(t, c, time.Now().Add(-48*time.Hour), 2000)
+	tests = []struct {
+		bytes   uint64
+		planned uint64
+		want    bool
+	}{
+		{bytes: 1000, want: true},
+		{bytes: 3000, want: true},
+		{bytes: 3001, want: false},
+		{bytes: 2000, planned: 100, want: false},
+		{bytes: 6000, want: false},
+	}
+	for _, test := range tests {
+		if got := c.budgetAllows(test.bytes, test.planned); got != test.want {
+			t.Errorf("budgetAllows(%d, %d) with volume = %v, want %v", test.bytes, test.planned, got, test.want)
+		}
+	}
+}
+
+func TestBytesLeft(t *testing.T) {
+	defer inTempDir(t)()
+
+	if err := ioutil.WriteFile("partial.mkv", []byte("12345"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("complete.mkv", []byte("123"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tasks := []DownloadTask{
+		{Destination: "partial.mkv", Size: 20},
+		{Destination: "complete.mkv", Size: 3},
+		{Destination: "missing.mkv", Size: 100},
+	}
+	if left := bytesLeft(tasks); left != 115 {
+		t.Errorf("bytesLeft() = %d, want 115", left)
+	}
+}
@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"pget/premiumize"
+	"strings"
+)
+
+// Category holds the download rules for transfers uploaded from a subfolder
+// of the upload directory. Categories are configured in pget.json, keyed by
+// the subfolder, and replace the rules given on the command line.
+type Category struct {
+	VideoOnly        bool            `mapstructure:"video_only"`
+	AudioOnly        bool            `mapstructure:"audio_only"`
+	Flatten          bool            `mapstructure:"flatten"`
+	Directory        string          `mapstructure:"directory"`
+	DeleteDownloaded bool            `mapstructure:"delete_downloaded"`
+	Manual           bool            `mapstructure:"manual"`
+	Hooks            map[string]Hook `mapstructure:"hooks"`
+	// Priority orders downloads with the priority transfer order, higher
+	// priorities first.
+	Priority int `mapstructure:"priority"`
+	// AsZip downloads transfers as a single zip archive, which is unpacked
+	// when Extract is set.
+	AsZip bool `mapstructure:"as_zip"`
+
+	// Extract unpacks downloaded zip and tar archives, including multi-part
+	// sets, next to the archive or into a subfolder named after it.
+	Extract          bool     `mapstructure:"extract"`
+	ExtractSubfolder bool     `mapstructure:"extract_subfolder"`
+	ExtractOnly      []string `mapstructure:"extract_only"`
+	DeleteArchives   bool     `mapstructure:"delete_archives"`
+}
+
+func (c *Cli) SetCategories(categories map[string]Category) error {
+	for name, category := range categories {
+		if category.AsZip && (category.VideoOnly || category.AudioOnly) && !category.Extract {
+			return fmt.Errorf("Category %s downloads the whole zip archive, video_only and audio_only need extract", name)
+		}
+	}
+
+	c.categories = categories
+	return nil
+}
+
+// categoryFor returns the category of the closest configured parent of a
+// location, or the given defaults if there is none.
+func (c *Cli) categoryFor(location string, defaults Category) Category {
+	for current := location; current != ""; current = parentLocation(current) {
+		for name, category := range c.categories {
+			if strings.EqualFold(strings.Trim(name, "/"), current) {
+				return category
+			}
+		}
+	}
+	return defaults
+}
+
+func parentLocation(location string) string {
+	index := strings.LastIndex(location, "/")
+	if index < 0 {
+		return ""
+	}
+	return location[:index]
+}
+
+func (category Category) filter() fileFilter {
+	return mediaFilter(category.VideoOnly, category.AudioOnly)
+}
+
+// extractFilter accepts the archive entries matching one of the extract_only
+// patterns, or all entries if there are none.
+func (category Category) extractFilter() fileFilter {
+	return func(file premiumize.TorrentContent) bool {
+		if len(category.ExtractOnly) == 0 {
+			return true
+		}
+		for _, pattern := range category.ExtractOnly {
+			if matched, _ := filepath.Match(strings.ToLower(pattern), strings.ToLower(file.Name)); matched {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// directory returns where transfers of this category are downloaded to. An
+// unset directory mirrors the upload location below the download directory.
+func (category Category) directory(targetDirectory string, location string) string {
+	if category.Directory != "" {
+		return category.Directory
+	}
+	return filepath.Join(targetDirectory, filepath.FromSlash(location))
+}
@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"os"
+	"path/filepath"
+	"pget/premiumize"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const premiumizeCachedStatus = "finished"
+const pendingDirectory = "pending"
+
+func (c *Cli) Check(links []string) {
+	var hashes []string
+	linkHashes := make(map[string]string)
+	for _, link := range links {
+		hash, err := linkInfoHash(link)
+		if err != nil {
+			fmt.Printf("* %s [invalid: %s]\n", link, err.Error())
+			continue
+		}
+		linkHashes[link] = hash
+		hashes = append(hashes, hash)
+	}
+
+	if len(hashes) == 0 {
+		return
+	}
+
+	cached, err := c.checkHashes(hashes)
+	if err != nil {
+		fmt.Printf("Unable to check cache: %s\n", err.Error())
+		return
+	}
+
+	for _, link := range links {
+		hash, ok := linkHashes[link]
+		if !ok {
+			continue
+		}
+
+		if status, ok := cached[hash]; ok {
+			fmt.Printf("* %s [cached] [%s] [%s]\n", link, status.Name, humanize.Bytes(uint64(status.Size)))
+		} else {
+			fmt.Printf("* %s [not cached]\n", link)
+		}
+	}
+}
+
+func linkInfoHash(link string) (string, error) {
+	if strings.HasPrefix(link, magnetPrefix) {
+		return infoHashFromMagnet(link)
+	}
+	return infoHash(link)
+}
+
+// checkHashes returns the cached entries of the given info hashes keyed by
+// their lower case hash. Hashes which are not cached are omitted.
+func (c *Cli) checkHashes(hashes []string) (map[string]premiumize.HashStatus, error) {
+	check, err := c.premiumize.CheckHashes(hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := make(map[string]premiumize.HashStatus)
+	for hash, status := range check.Hashes {
+		if status.Status == premiumizeCachedStatus {
+			cached[strings.ToLower(hash)] = status
+		}
+	}
+	return cached, nil
+}
+
+func (c *Cli) isCached(filePath string) (bool, error) {
+	hash, err := infoHash(filePath)
+	if err != nil {
+		return false, err
+	}
+
+	cached, err := c.checkHashes([]string{hash})
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := cached[hash]
+	return ok, nil
+}
+
+// pendingPattern matches the pending directory of an upload directory, which
+// the upload watcher has to ignore.
+func pendingPattern(basePath string) string {
+	return "^" + regexp.QuoteMeta(filepath.Join(filepath.Clean(basePath), pendingDirectory)) + "$"
+}
+
+// moveToPending moves a file which is not yet cached into the pending
+// directory of the upload directory, keeping its subfolder.
+func (c *Cli) moveToPending(basePath string, filePath string) {
+	relative, err := filepath.Rel(basePath, filePath)
+	if err != nil {
+		fmt.Printf("Unable to move %s to pending: %s\n", filePath, err.Error())
+		return
+	}
+	pendingPath := filepath.Join(basePath, pendingDirectory, relative)
+
+	if err := os.MkdirAll(filepath.Dir(pendingPath), 0770); err != nil {
+		fmt.Printf("Unable to create pending directory: %s\n", err.Error())
+		return
+	}
+
+	if err := os.Rename(filePath, pendingPath); err != nil {
+		fmt.Printf("Unable to move %s to pending: %s\n", filePath, err.Error())
+		return
+	}
+	fmt.Printf("%s is not cached, moved to %s\n", filePath, pendingPath)
+}
+
+// recheckPending periodically checks the files in the pending directory and
+// moves the ones that became cached back into the upload directory, where
+// the upload watcher picks them up again.
+func (c *Cli) recheckPending(ctx context.Context, basePath string, interval time.Duration) {
+	pendingPath := filepath.Join(basePath, pendingDirectory)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		files := make(map[string]string)
+		var hashes []string
+		filepath.Walk(pendingPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+
+			hash, err := infoHash(path)
+			if err != nil {
+				fmt.Printf("Unable to determine info hash of %s: %s\n", path, err.Error())
+				return nil
+			}
+			files[path] = hash
+			hashes = append(hashes, hash)
+			return nil
+		})
+
+		if len(hashes) == 0 {
+			continue
+		}
+
+		cached, err := c.checkHashes(hashes)
+		if err != nil {
+			fmt.Printf("Unable to check cache of pending files: %s\n", err.Error())
+			continue
+		}
+
+		for path, hash := range files {
+			if _, ok := cached[hash]; !ok {
+				continue
+			}
+
+			uploadPath := filepath.Join(basePath, path[len(pendingPath)+1:])
+			if err := os.MkdirAll(filepath.Dir(uploadPath), 0770); err != nil {
+				fmt.Printf("Unable to create upload directory: %s\n", err.Error())
+				continue
+			}
+			if err := os.Rename(path, uploadPath); err != nil {
+				fmt.Printf("Unable to move %s back for upload: %s\n", path, err.Error())
+				continue
+			}
+			fmt.Printf("%s is now cached, queued for upload\n", uploadPath)
+		}
+	}
+}
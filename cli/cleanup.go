@@ -0,0 +1,221 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/dustin/go-humanize"
+	"pget/premiumize"
+	"sort"
+	"strings"
+	"time"
+)
+
+const seenBucket = "seen"
+const downloadedBucket = "downloaded"
+
+// CleanupConfig holds the rules for removing transfers from premiumize. Only
+// finished transfers are removed by age, download and size; transfers in one
+// of the given statuses are removed right away.
+type CleanupConfig struct {
+	Status []string `mapstructure:"status"`
+	// MaxAge counts from when watch or cleanup first listed the transfer, as
+	// premiumize does not tell when it was created.
+	MaxAge     time.Duration `mapstructure:"max_age"`
+	Downloaded bool          `mapstructure:"downloaded"`
+	// MaxSize removes the oldest transfers until all of them fit, e.g. 500gb.
+	MaxSize string `mapstructure:"max_size"`
+}
+
+func (config CleanupConfig) enabled() bool {
+	return len(config.Status) > 0 || config.MaxAge > 0 || config.Downloaded || config.MaxSize != ""
+}
+
+func (c *Cli) SetCleanup(config CleanupConfig) error {
+	maxSize, err := parseStopAfter(config.MaxSize)
+	if err != nil {
+		return fmt.Errorf("Invalid max_size %s: %s", config.MaxSize, err.Error())
+	}
+
+	c.cleanupConfig = config
+	c.cleanupMaxSize = maxSize
+	return nil
+}
+
+// Cleanup removes the transfers matching the cleanup rules. A dry run only
+// lists them and does not record the transfers as seen.
+func (c *Cli) Cleanup(dryRun bool) {
+	if !c.cleanupConfig.enabled() {
+		fmt.Println("No cleanup rules configured")
+		return
+	}
+
+	torrents, err := c.premiumize.ListTorrents()
+	if err != nil {
+		fmt.Printf("Could not retrieve list of torrents: %s\n", err.Error())
+		return
+	}
+	c.cleanup(torrents.Transfers, nil, dryRun)
+}
+
+type cleanupCandidate struct {
+	Transfer premiumize.TorrentItem
+	Reason   string
+}
+
+// cleanup removes the cleanup candidates, except for the pending transfers
+// watch still has to download.
+func (c *Cli) cleanup(transfers []premiumize.TorrentItem, pending map[string]premiumize.TorrentItem, dryRun bool) {
+	var seen map[string]time.Time
+	if dryRun {
+		seen = c.seenBefore(transfers)
+	} else {
+		var err error
+		if seen, err = c.firstSeen(transfers); err != nil {
+			fmt.Printf("Could not track transfers: %s\n", err.Error())
+			return
+		}
+	}
+
+	for _, candidate := range c.cleanupCandidates(transfers, seen, pending) {
+		if dryRun {
+			fmt.Printf("* %s [%s] [%s]\n", candidate.Transfer.Name, humanize.Bytes(uint64(candidate.Transfer.Size)), candidate.Reason)
+			continue
+		}
+
+		if _, err := c.premiumize.DeleteTorrent(candidate.Transfer.ID); err != nil {
+			fmt.Printf("Unable to delete %s: %s\n", candidate.Transfer.Name, err.Error())
+			continue
+		}
+		fmt.Printf("Deleted %s from premiumize [%s]\n", candidate.Transfer.Name, candidate.Reason)
+	}
+}
+
+// cleanupCandidates returns the transfers to remove, oldest first according to
+// seen. Pending transfers and transfers with a scheduled or cancelled deletion
+// are left alone.
+func (c *Cli) cleanupCandidates(transfers []premiumize.TorrentItem, seen map[string]time.Time, pending map[string]premiumize.TorrentItem) []cleanupCandidate {
+	config := c.cleanupConfig
+	maxSize := c.cleanupMaxSize
+
+	sorted := append([]premiumize.TorrentItem(nil), transfers...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return seen[sorted[i].ID].Before(seen[sorted[j].ID])
+	})
+
+	var total uint64
+	for _, transfer := range sorted {
+		total += uint64(transfer.Size)
+	}
+
+	var candidates []cleanupCandidate
+	for _, transfer := range sorted {
+		if _, ok := pending[transfer.ID]; ok || c.isDeletionScheduled(transfer.ID) {
+			continue
+		}
+
+		reason := ""
+		switch {
+		case containsFold(config.Status, transfer.Status):
+			reason = "status " + transfer.Status
+		case !c.isTorrentFinished(transfer.Status):
+		case config.MaxAge > 0 && time.Since(seen[transfer.ID]) > config.MaxAge:
+			reason = "older than " + config.MaxAge.String()
+		case config.Downloaded && c.isDownloaded(transfer.ID):
+			reason = "downloaded"
+		case maxSize > 0 && total > maxSize:
+			reason = "over " + humanize.Bytes(maxSize)
+		}
+
+		if reason != "" {
+			candidates = append(candidates, cleanupCandidate{Transfer: transfer, Reason: reason})
+			total -= uint64(transfer.Size)
+		}
+	}
+	return candidates
+}
+
+// firstSeen returns when pget saw each of the transfers for the first time and
+// forgets about transfers which no longer exist.
+func (c *Cli) firstSeen(transfers []premiumize.TorrentItem) (map[string]time.Time, error) {
+	seen := make(map[string]time.Time)
+	err := c.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(seenBucket))
+		if err != nil {
+			return err
+		}
+
+		for _, transfer := range transfers {
+			first, err := time.Parse(time.RFC3339, string(bucket.Get([]byte(transfer.ID))))
+			if err != nil {
+				first = time.Now()
+				if err := bucket.Put([]byte(transfer.ID), []byte(first.Format(time.RFC3339))); err != nil {
+					return err
+				}
+			}
+			seen[transfer.ID] = first
+		}
+
+		var stale [][]byte
+		bucket.ForEach(func(key []byte, value []byte) error {
+			if _, ok := seen[string(key)]; !ok {
+				stale = append(stale, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		for _, key := range stale {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return seen, err
+}
+
+// seenBefore is firstSeen without recording anything. Transfers pget has not
+// seen yet are new.
+func (c *Cli) seenBefore(transfers []premiumize.TorrentItem) map[string]time.Time {
+	seen := make(map[string]time.Time)
+	for _, transfer := range transfers {
+		seen[transfer.ID] = time.Now()
+	}
+
+	err := c.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(seenBucket))
+		if bucket == nil {
+			return nil
+		}
+		for _, transfer := range transfers {
+			if first, err := time.Parse(time.RFC3339, string(bucket.Get([]byte(transfer.ID)))); err == nil {
+				seen[transfer.ID] = first
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Could not read age of transfers: %s\n", err.Error())
+	}
+	return seen
+}
+
+// markDownloaded records that all files of a transfer have been downloaded.
+func (c *Cli) markDownloaded(id string) {
+	if err := c.putJSON(downloadedBucket, id, time.Now()); err != nil {
+		fmt.Printf("Could not record download of %s: %s\n", id, err.Error())
+	}
+}
+
+func (c *Cli) isDownloaded(id string) bool {
+	var downloaded time.Time
+	found, _ := c.getJSON(downloadedBucket, id, &downloaded)
+	return found
+}
+
+func containsFold(values []string, value string) bool {
+	for _, current := range values {
+		if strings.EqualFold(current, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"os"
+	"pget/premiumize"
+	"testing"
+)
+
+func TestSetCleanup(t *testing.T) {
+	c := New(nil)
+	if err := c.SetCleanup(CleanupConfig{MaxSize: "500gb"}); err != nil {
+		t.Errorf("SetCleanup(500gb) failed: %s", err)
+	}
+	if c.cleanupMaxSize != 500000000000 {
+		t.Errorf("max size %d, want 500000000000", c.cleanupMaxSize)
+	}
+	if err := c.SetCleanup(CleanupConfig{MaxSize: "500 apples"}); err == nil {
+		t.Errorf("SetCleanup(500 apples) accepted an invalid size")
+	}
+}
+
+func TestCleanupDryRunRecordsNothing(t *testing.T) {
+	defer inTempDir(t)()
+
+	c := New(nil)
+	if err := c.SetCleanup(CleanupConfig{Status: []string{"error"}}); err != nil {
+		t.Fatal(err)
+	}
+	c.cleanup([]premiumize.TorrentItem{{ID: "1", Name: "broken", Status: "error"}}, nil, true)
+
+	if _, err := os.Stat(boltDBFile); !os.IsNotExist(err) {
+		t.Errorf("dry run created %s", boltDBFile)
+	}
+}
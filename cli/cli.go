@@ -1,15 +1,39 @@
 package cli
 
 import (
-	"github.com/boltdb/bolt"
 	"pget/premiumize"
 	"sync"
+	"time"
 )
 
 type Cli struct {
 	premiumize *premiumize.Client
-	bolt       *bolt.DB
+	boltLock   *lockFile
 	boltMutex  sync.Mutex
+
+	cloudFolders      map[string]string
+	cloudFoldersMutex sync.Mutex
+
+	categories         map[string]Category
+	watcherPolling     bool
+	watcherQuietPeriod time.Duration
+	shutdownTimeout    time.Duration
+	cleanupConfig      CleanupConfig
+	cleanupMaxSize     uint64
+	stalledConfig      StalledConfig
+	accountConfig      AccountConfig
+	scheduleWindows    []scheduleWindow
+	schedulePause      bool
+	budgets            []volumeBudget
+	orderConfig        OrderConfig
+
+	uploadsPaused bool
+	accountMutex  sync.Mutex
+
+	hooks              map[string]Hook
+	hooksRunning       sync.WaitGroup
+	hookSlotsByCommand map[string]chan struct{}
+	hookSlotsMutex     sync.Mutex
 }
 
 func New(client *premiumize.Client) *Cli {
@@ -17,3 +41,34 @@ func New(client *premiumize.Client) *Cli {
 		premiumize: client,
 	}
 }
+
+// SetWatcherPolling makes the upload watcher scan the upload directory instead
+// of relying on file system events, e.g. for NFS or SMB mounts.
+func (c *Cli) SetWatcherPolling(enabled bool) {
+	c.watcherPolling = enabled
+}
+
+// SetWatcherQuietPeriod sets how long a file in the upload directory has to
+// stay unchanged before it is uploaded.
+func (c *Cli) SetWatcherQuietPeriod(quietPeriod time.Duration) {
+	c.watcherQuietPeriod = quietPeriod
+}
+
+// SetShutdownTimeout sets how long running downloads may take to finish after
+// a shutdown has been requested.
+func (c *Cli) SetShutdownTimeout(timeout time.Duration) {
+	c.shutdownTimeout = timeout
+}
+
+// Close waits for running hooks and releases the database.
+func (c *Cli) Close() {
+	c.hooksRunning.Wait()
+
+	c.boltMutex.Lock()
+	defer c.boltMutex.Unlock()
+
+	if c.boltLock != nil {
+		c.boltLock.Release()
+		c.boltLock = nil
+	}
+}
@@ -0,0 +1,280 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"path"
+	"pget/premiumize"
+	"strings"
+)
+
+var cloudRoot = premiumize.CloudItem{Type: premiumize.CloudFolderType}
+
+func (c *Cli) CloudList(cloudPath string) {
+	folder, err := c.findCloudItem(cloudPath)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	if folder.Type != premiumize.CloudFolderType {
+		size, _ := folder.Size.Int64()
+		fmt.Printf("* %s [%s]\n", folder.Name, humanize.Bytes(uint64(size)))
+		return
+	}
+
+	list, err := c.premiumize.ListFolder(folder.ID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	for _, item := range list.Content {
+		if item.Type == premiumize.CloudFolderType {
+			fmt.Printf("* %s/\n", item.Name)
+		} else {
+			size, _ := item.Size.Int64()
+			fmt.Printf("* %s [%s]\n", item.Name, humanize.Bytes(uint64(size)))
+		}
+	}
+}
+
+func (c *Cli) CloudTree(cloudPath string) {
+	content, err := c.browseCloudItem(cloudPath)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	fmt.Println(".")
+	printTorrent(0, content)
+}
+
+func (c *Cli) CloudRemove(cloudPath string) {
+	item, err := c.findCloudItem(cloudPath)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	if item.ID == "" {
+		fmt.Println("Refusing to delete the cloud root folder")
+		return
+	}
+
+	if item.Type == premiumize.CloudFolderType {
+		_, err = c.premiumize.DeleteFolder(item.ID)
+	} else {
+		_, err = c.premiumize.DeleteItem(item.ID)
+	}
+
+	if err != nil {
+		fmt.Printf("Unable to delete %s: %s\n", cloudPath, err.Error())
+		return
+	}
+	fmt.Printf("Deleted %s\n", cloudPath)
+}
+
+func (c *Cli) CloudRename(cloudPath string, name string) {
+	if strings.Contains(name, "/") {
+		fmt.Println("Only renaming within the same folder is supported")
+		return
+	}
+
+	item, err := c.findCloudItem(cloudPath)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	if item.ID == "" {
+		fmt.Println("Refusing to rename the cloud root folder")
+		return
+	}
+
+	if item.Type == premiumize.CloudFolderType {
+		_, err = c.premiumize.RenameFolder(item.ID, name)
+	} else {
+		_, err = c.premiumize.RenameItem(item.ID, name)
+	}
+
+	if err != nil {
+		fmt.Printf("Unable to rename %s: %s\n", cloudPath, err.Error())
+		return
+	}
+	fmt.Printf("Renamed %s to %s\n", cloudPath, name)
+}
+
+func (c *Cli) CloudGet(cloudPath string, targetDirectory string, videoOnly bool, flatten bool, stopAfter string) error {
+	bytes, err := parseStopAfter(stopAfter)
+	if err != nil {
+		fmt.Printf("Unable to parse %s. Error: %s\n", stopAfter, err.Error())
+	}
+
+	content, err := c.browseCloudItem(cloudPath)
+	if err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
+
+	tasks := createDownloadList(targetDirectory, content, mediaFilter(videoOnly, false), flatten)
+	return c.download(context.Background(), tasks, downloadLimit{stopAfter: bytes})
+}
+
+// browseCloudItem returns the content below the given cloud path. A file is
+// returned on its own, a folder with all of its files.
+func (c *Cli) browseCloudItem(cloudPath string) (map[string]premiumize.TorrentContent, error) {
+	item, err := c.findCloudItem(cloudPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if item.Type != premiumize.CloudFolderType {
+		return map[string]premiumize.TorrentContent{
+			item.Name: premiumize.CloudFileContent(item, item.Name),
+		}, nil
+	}
+
+	return c.premiumize.BrowseFolder(item)
+}
+
+// findCloudItem resolves a slash separated path, starting at the cloud root
+// folder, to the item it names.
+func (c *Cli) findCloudItem(cloudPath string) (premiumize.CloudItem, error) {
+	current := cloudRoot
+
+	for _, name := range strings.Split(path.Clean("/"+cloudPath), "/") {
+		if name == "" {
+			continue
+		}
+
+		if current.Type != premiumize.CloudFolderType {
+			return premiumize.CloudItem{}, fmt.Errorf("%s is not a folder", current.Name)
+		}
+
+		list, err := c.premiumize.ListFolder(current.ID)
+		if err != nil {
+			return premiumize.CloudItem{}, err
+		}
+
+		found := false
+		for _, item := range list.Content {
+			if item.Name == name {
+				current = item
+				found = true
+				break
+			}
+		}
+		if !found {
+			return premiumize.CloudItem{}, fmt.Errorf("Unable to find '%s' in the cloud", cloudPath)
+		}
+	}
+
+	return current, nil
+}
+
+// ensureCloudFolder returns the id of the cloud folder matching an upload
+// location, creating missing folders on the way. The root folder is used for
+// an empty location. The ids are cached, so a
+// lookup that fails with cached ids, e.g. because a folder was deleted on
+// premiumize in the meantime, is repeated once without them.
+func (c *Cli) ensureCloudFolder(location string) (string, error) {
+	id, cached, err := c.cloudFolderID(location)
+	if err != nil && cached {
+		c.forgetCloudFolder(location)
+		id, _, err = c.cloudFolderID(location)
+	}
+	return id, err
+}
+
+// cloudFolderID looks up or creates the folders of a location and reports
+// whether cached ids were used.
+func (c *Cli) cloudFolderID(location string) (string, bool, error) {
+	if location == "" {
+		return "", false, nil
+	}
+
+	c.cloudFoldersMutex.Lock()
+	defer c.cloudFoldersMutex.Unlock()
+
+	if c.cloudFolders == nil {
+		c.cloudFolders = make(map[string]string)
+	}
+
+	parentID := ""
+	current := ""
+	cached := false
+	for _, name := range strings.Split(location, "/") {
+		current = path.Join(current, name)
+		if id, ok := c.cloudFolders[current]; ok {
+			parentID = id
+			cached = true
+			continue
+		}
+
+		id, err := c.findOrCreateCloudFolder(parentID, name)
+		if err != nil {
+			return "", cached, err
+		}
+		c.cloudFolders[current] = id
+		parentID = id
+	}
+
+	return parentID, cached, nil
+}
+
+// forgetCloudFolder drops the cached ids of the top folder of a location and
+// of every folder below it, as any of them may be stale.
+func (c *Cli) forgetCloudFolder(location string) {
+	c.cloudFoldersMutex.Lock()
+	defer c.cloudFoldersMutex.Unlock()
+
+	top := strings.Split(location, "/")[0]
+	for cached := range c.cloudFolders {
+		if cached == top || strings.HasPrefix(cached, top+"/") {
+			delete(c.cloudFolders, cached)
+		}
+	}
+}
+
+// uploadToCloudFolder uploads a torrent or magnet file into the cloud folder
+// of a location. When the upload fails, the folder is looked up again without
+// the cached ids and, if it turns out to have changed, the upload is retried
+// once.
+func (c *Cli) uploadToCloudFolder(filePath string, location string) (premiumize.UploadResponse, error) {
+	folderID, err := c.ensureCloudFolder(location)
+	if err != nil {
+		return premiumize.UploadResponse{}, err
+	}
+
+	resp, err := c.upload(filePath, folderID)
+	if err == nil || location == "" {
+		return resp, err
+	}
+
+	c.forgetCloudFolder(location)
+	if newID, lookupErr := c.ensureCloudFolder(location); lookupErr == nil && newID != folderID {
+		return c.upload(filePath, newID)
+	}
+	return resp, err
+}
+
+func (c *Cli) findOrCreateCloudFolder(parentID string, name string) (string, error) {
+	list, err := c.premiumize.ListFolder(parentID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, item := range list.Content {
+		if item.Type == premiumize.CloudFolderType && item.Name == name {
+			return item.ID, nil
+		}
+	}
+
+	resp, err := c.premiumize.CreateFolder(name, parentID)
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"path"
+	"pget/premiumize"
+	"reflect"
+	"testing"
+)
+
+// cloudFolder builds the content BrowseFolder returns for a folder holding the
+// given files and folders.
+func cloudFolder(name string, items ...interface{}) premiumize.TorrentContent {
+	folder := premiumize.TorrentContent{Type: "dir", Name: path.Base(name), Path: name, Children: make(map[string]premiumize.TorrentContent)}
+	for _, item := range items {
+		switch item := item.(type) {
+		case string:
+			folder.Children[item] = premiumize.CloudFileContent(premiumize.CloudItem{Name: item, Link: "https://example.com/" + item}, path.Join(name, item))
+		case premiumize.TorrentContent:
+			folder.Children[item.Name] = item
+		}
+	}
+	return folder
+}
+
+func TestCloudGetTasks(t *testing.T) {
+	content := cloudFolder("movies",
+		"movie.mkv",
+		"../../.bashrc",
+		cloudFolder("movies/extra", "sample.mkv", "../../../evil.sh"),
+	).Children
+
+	tests := []struct {
+		flatten bool
+		want    []string
+	}{
+		{want: []string{"movies/extra/sample.mkv", "movies/movie.mkv"}},
+		{flatten: true, want: []string{"movie.mkv", "sample.mkv"}},
+	}
+
+	for _, test := range tests {
+		var destinations []string
+		for _, task := range createDownloadList("target", content, mediaFilter(false, false), test.flatten) {
+			destinations = append(destinations, task.Destination)
+		}
+		if got := relativePaths(t, "target", destinations); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("flatten %v: downloads %v, want %v", test.flatten, got, test.want)
+		}
+	}
+}
+
+func TestForgetCloudFolder(t *testing.T) {
+	c := New(nil)
+	c.cloudFolders = map[string]string{"movies": "1", "movies/hd": "2", "movies/hd/new": "3", "movies2": "4", "series": "5"}
+
+	if id, cached, err := c.cloudFolderID("movies/hd/new"); id != "3" || !cached || err != nil {
+		t.Errorf("cloudFolderID() = %q, %v, %v, want the cached id", id, cached, err)
+	}
+
+	c.forgetCloudFolder("movies/hd")
+	want := map[string]string{"movies2": "4", "series": "5"}
+	if !reflect.DeepEqual(c.cloudFolders, want) {
+		t.Errorf("cached folders %v, want %v", c.cloudFolders, want)
+	}
+}
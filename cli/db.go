@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"os"
+	"time"
+)
+
+// withDB opens the database for the duration of fn. Other processes, like a
+// running watch, only hold the database while they access it, so this waits
+// for them for a while.
+func (c *Cli) withDB(readOnly bool, fn func(db *bolt.DB) error) error {
+	c.boltMutex.Lock()
+	defer c.boltMutex.Unlock()
+
+	db, err := bolt.Open(boltDBFile, 0600, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: readOnly})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return fn(db)
+}
+
+func (c *Cli) update(fn func(tx *bolt.Tx) error) error {
+	return c.withDB(false, func(db *bolt.DB) error {
+		return db.Update(fn)
+	})
+}
+
+// view opens the database read-only and does not create it. Without a
+// database fn is not called, as if there was nothing stored.
+func (c *Cli) view(fn func(tx *bolt.Tx) error) error {
+	if _, err := os.Stat(boltDBFile); os.IsNotExist(err) {
+		return nil
+	}
+	return c.withDB(true, func(db *bolt.DB) error {
+		return db.View(fn)
+	})
+}
+
+// putJSON stores a value as JSON in the given bucket, creating the bucket if
+// needed.
+func (c *Cli) putJSON(bucketName string, key string, value interface{}) error {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return c.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), content)
+	})
+}
+
+// putJSONTx stores a value as JSON within a running transaction.
+func putJSONTx(bucket *bolt.Bucket, key string, value interface{}) error {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), content)
+}
+
+// getJSON loads a value stored with putJSON. It returns false if the key does
+// not exist.
+func (c *Cli) getJSON(bucketName string, key string, value interface{}) (bool, error) {
+	var content []byte
+	err := c.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		if bucket == nil {
+			return nil
+		}
+		if data := bucket.Get([]byte(key)); data != nil {
+			content = append([]byte(nil), data...)
+		}
+		return nil
+	})
+
+	if err != nil || content == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(content, value)
+}
+
+// deleteKey removes a key from the given bucket.
+func (c *Cli) deleteKey(bucketName string, key string) error {
+	return c.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(key))
+	})
+}
+
+// forEachJSON calls fn with every value of the given bucket, decoded into a new
+// value created by newValue. fn must not access the database itself.
+func (c *Cli) forEachJSON(bucketName string, newValue func() interface{}, fn func(key string, value interface{})) error {
+	return c.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(key []byte, data []byte) error {
+			value := newValue()
+			if err := json.Unmarshal(data, value); err != nil {
+				return err
+			}
+			fn(string(key), value)
+			return nil
+		})
+	})
+}
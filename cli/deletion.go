@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"pget/premiumize"
+	"strings"
+	"time"
+)
+
+const deletionsBucket = "deletions"
+
+// scheduledDeletion is a downloaded transfer waiting for its remote deletion.
+// Cancelled deletions are kept, so the transfer is neither downloaded nor
+// scheduled again, until the transfer is gone.
+type scheduledDeletion struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scheduled time.Time `json:"scheduled"`
+	Due       time.Time `json:"due"`
+	Cancelled bool      `json:"cancelled"`
+}
+
+// checkDownloaded makes sure all selected files of a download are on disk with
+// the expected size.
+func checkDownloaded(result downloadResult) error {
+	for _, task := range result.Tasks {
+		stat, err := os.Stat(task.Destination)
+		if err != nil {
+			return fmt.Errorf("%s is missing", task.Destination)
+		}
+		if uint64(stat.Size()) != task.Size {
+			return fmt.Errorf("%s has %d bytes, expected %d", task.Destination, stat.Size(), task.Size)
+		}
+	}
+	return nil
+}
+
+// scheduleDeletion deletes the remote transfer once the grace period is over,
+// unless it is cancelled with undelete meanwhile.
+func (c *Cli) scheduleDeletion(transfer premiumize.TorrentItem, gracePeriod time.Duration) {
+	var deletion scheduledDeletion
+	if found, err := c.getJSON(deletionsBucket, transfer.ID, &deletion); err != nil || found {
+		return
+	}
+
+	deletion = scheduledDeletion{
+		ID:        transfer.ID,
+		Name:      transfer.Name,
+		Hash:      transfer.Hash,
+		Scheduled: time.Now(),
+		Due:       time.Now().Add(gracePeriod),
+	}
+	if err := c.putJSON(deletionsBucket, transfer.ID, deletion); err != nil {
+		fmt.Printf("Could not schedule deletion of %s: %s\n", transfer.Name, err.Error())
+		return
+	}
+	fmt.Printf("%s will be deleted from premiumize at %s\n", transfer.Name, deletion.Due.Format(time.RFC822))
+}
+
+// isDeletionScheduled returns whether a transfer has been downloaded and
+// scheduled for deletion, even if the deletion has been cancelled.
+func (c *Cli) isDeletionScheduled(id string) bool {
+	var deletion scheduledDeletion
+	found, _ := c.getJSON(deletionsBucket, id, &deletion)
+	return found
+}
+
+func (c *Cli) scheduledDeletions() []scheduledDeletion {
+	var deletions []scheduledDeletion
+	err := c.forEachJSON(deletionsBucket, func() interface{} { return &scheduledDeletion{} }, func(key string, value interface{}) {
+		deletions = append(deletions, *value.(*scheduledDeletion))
+	})
+	if err != nil {
+		fmt.Printf("Could not read scheduled deletions: %s\n", err.Error())
+	}
+	return deletions
+}
+
+// deleteDue deletes the transfers whose grace period is over and forgets about
+// deletions of transfers which no longer exist.
+func (c *Cli) deleteDue(transfers []premiumize.TorrentItem) {
+	existing := make(map[string]bool)
+	for _, transfer := range transfers {
+		existing[transfer.ID] = true
+	}
+
+	for _, deletion := range c.scheduledDeletions() {
+		if !existing[deletion.ID] {
+			c.deleteKey(deletionsBucket, deletion.ID)
+			continue
+		}
+		if deletion.Cancelled || time.Now().Before(deletion.Due) {
+			continue
+		}
+
+		if _, err := c.premiumize.DeleteTorrent(deletion.ID); err != nil {
+			fmt.Printf("Unable to delete %s: %s\n", deletion.Name, err.Error())
+			continue
+		}
+		fmt.Printf("Deleted %s from premiumize\n", deletion.Name)
+		c.deleteKey(deletionsBucket, deletion.ID)
+	}
+}
+
+// Undelete cancels the scheduled deletion of a transfer, given by name or id.
+// Without a name the scheduled deletions are listed.
+func (c *Cli) Undelete(name string) {
+	deletions := c.scheduledDeletions()
+
+	if name == "" {
+		for _, deletion := range deletions {
+			if !deletion.Cancelled {
+				fmt.Printf("* %s [%s]\n", deletion.Name, deletion.Due.Format(time.RFC822))
+			}
+		}
+		return
+	}
+
+	for _, deletion := range deletions {
+		if deletion.Cancelled || (deletion.ID != name && !strings.EqualFold(deletion.Name, name)) {
+			continue
+		}
+
+		deletion.Cancelled = true
+		if err := c.putJSON(deletionsBucket, deletion.ID, deletion); err != nil {
+			fmt.Printf("Unable to cancel deletion of %s: %s\n", deletion.Name, err.Error())
+			return
+		}
+		fmt.Printf("Cancelled deletion of %s\n", deletion.Name)
+		return
+	}
+	fmt.Printf("No deletion of '%s' is scheduled\n", name)
+}
@@ -1,13 +1,13 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"github.com/cavaliercoder/grab"
 	"github.com/dustin/go-humanize"
 	"os"
 	"path/filepath"
 	"pget/premiumize"
-	"sort"
 	"strings"
 	"time"
 )
@@ -15,12 +15,27 @@ import (
 const typeFile = "file"
 const typeDir = "dir"
 
+var errInterrupted = fmt.Errorf("Download interrupted")
+var errLimitReached = fmt.Errorf("Download limit reached")
+
 type DownloadTask struct {
-	Destination string
-	URL         string
-	Size        uint64
+	Destination string `json:"destination"`
+	URL         string `json:"url"`
+	Size        uint64 `json:"size"`
+}
+
+// downloadLimit bounds a download. stopAfter stops before the first new file
+// that would exceed it. Budgeted downloads, those of watch, are deferred as a
+// whole while they do not fit into the download budget and their volume is
+// recorded.
+type downloadLimit struct {
+	stopAfter uint64
+	budgeted  bool
 }
 
+// fileFilter decides whether a file of a torrent is downloaded.
+type fileFilter func(file premiumize.TorrentContent) bool
+
 type DownloadTaskSorter []DownloadTask
 
 func (a DownloadTaskSorter) Len() int      { return len(a) }
@@ -29,14 +44,16 @@ func (a DownloadTaskSorter) Less(i, j int) bool {
 	return strings.Compare(a[i].Destination, a[j].Destination) < 0
 }
 
-func (c *Cli) DownloadTorrent(name string, targetDirectory string, videoOnly bool, flatten bool, stopAfter string) (string, error) {
-	var bytes uint64 = 0
-	if stopAfter != "" {
-		var err error
-		bytes, err = humanize.ParseBytes(stopAfter)
-		if err != nil {
-			fmt.Printf("Unable to parse %s. Error: %s\n", stopAfter, err.Error())
-		}
+func (c *Cli) DownloadTorrent(name string, targetDirectory string, videoOnly bool, flatten bool, stopAfter string, asZip bool, unpack bool) (string, error) {
+	bytes, err := parseStopAfter(stopAfter)
+	if err != nil {
+		fmt.Printf("Unable to parse %s. Error: %s\n", stopAfter, err.Error())
+	}
+
+	if asZip && videoOnly && !unpack {
+		err := fmt.Errorf("--video-only needs --unpack to apply to --as-zip downloads")
+		fmt.Println(err.Error())
+		return "", err
 	}
 
 	torrentInfo, err := c.premiumize.FindTorrentByName(name)
@@ -45,55 +62,154 @@ func (c *Cli) DownloadTorrent(name string, targetDirectory string, videoOnly boo
 		return "", err
 	}
 
-	torrent, err := c.premiumize.BrowseTorrent(torrentInfo.Hash)
+	if asZip {
+		_, err = c.downloadTransferZip(context.Background(), torrentInfo, targetDirectory, mediaFilter(videoOnly, false), flatten, unpack, downloadLimit{stopAfter: bytes})
+	} else {
+		_, err = c.downloadTransfer(context.Background(), torrentInfo, targetDirectory, mediaFilter(videoOnly, false), flatten, downloadLimit{stopAfter: bytes})
+	}
+	return torrentInfo.ID, err
+}
+
+// downloadResult describes the local files of a downloaded transfer.
+type downloadResult struct {
+	Transfer premiumize.TorrentItem
+	// Folder is the folder of the transfer, see transferFolder.
+	Folder string
+	// SharedFolder is set if Folder is the download directory itself and
+	// may hold files of other transfers.
+	SharedFolder bool
+	Tasks        []DownloadTask
+	Started      time.Time
+	Completed    time.Time
+}
+
+func (c *Cli) downloadTransfer(ctx context.Context, transfer premiumize.TorrentItem, targetDirectory string, filter fileFilter, flatten bool, limit downloadLimit) (downloadResult, error) {
+	torrent, err := c.premiumize.BrowseTorrent(transfer.Hash)
 
 	if err != nil {
 		fmt.Println(err.Error())
-		return "", err
+		return downloadResult{}, err
+	}
+
+	folder := transferFolder(targetDirectory, torrent.Content, flatten)
+	result := downloadResult{
+		Transfer:     transfer,
+		Folder:       folder,
+		SharedFolder: folder == targetDirectory,
+		Tasks:        createDownloadList(targetDirectory, torrent.Content, filter, flatten),
+		Started:      time.Now(),
+	}
+	if len(result.Tasks) == 0 {
+		result.Completed = result.Started
+		return result, nil
+	}
+
+	marker, err := createInProgressMarker(result.Folder, transfer)
+	if err != nil {
+		fmt.Printf("Could not create in-progress marker: %s\n", err.Error())
+	} else {
+		defer removeMarker(marker)
 	}
 
-	tasks := createDownloadList(targetDirectory, torrent.Content, videoOnly, flatten)
-	return torrentInfo.ID, download(tasks, bytes)
+	if err := c.download(ctx, result.Tasks, limit); err != nil {
+		return result, err
+	}
+	result.Completed = time.Now()
+	return result, nil
 }
 
-func download(tasks []DownloadTask, stopAfterBytes uint64) error {
-	sort.Sort(DownloadTaskSorter(tasks))
+func parseStopAfter(stopAfter string) (uint64, error) {
+	if stopAfter == "" {
+		return 0, nil
+	}
+	return humanize.ParseBytes(stopAfter)
+}
+
+// download fetches the given tasks one after another. Once the context is
+// done no further task is started.
+func (c *Cli) download(ctx context.Context, tasks []DownloadTask, limit downloadLimit) error {
+	c.orderTasks(tasks)
+
+	if limit.budgeted && !c.budgetAllows(bytesLeft(tasks), 0) {
+		return errBudgetExceeded
+	}
 
+	stopAfterBytes := limit.stopAfter
 	var totalBytes uint64
 	for _, task := range tasks {
+		if ctx.Err() != nil {
+			return errInterrupted
+		}
+
 		if stopAfterBytes != 0 {
 			if _, err := os.Stat(task.Destination); err != nil {
 				totalBytes += task.Size
 				if totalBytes > stopAfterBytes {
-					fmt.Printf("Stopping download. Reached %s. The next download would overstep the %s limit.", humanize.Bytes(totalBytes-task.Size), humanize.Bytes(stopAfterBytes))
-					return err
+					fmt.Printf("Stopping download. Reached %s. The next download would overstep the %s limit.\n", humanize.Bytes(totalBytes-task.Size), humanize.Bytes(stopAfterBytes))
+					return errLimitReached
 				}
 			}
 		}
 
-		return downloadTask(task)
+		transferred, err := c.downloadTask(ctx, task)
+		if limit.budgeted {
+			c.recordVolume(transferred)
+		}
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func createDownloadList(root string, torrent map[string]premiumize.TorrentContent, videoOnly bool, flatten bool) []DownloadTask {
+// bytesLeft returns how many bytes of the tasks are not on disk yet.
+func bytesLeft(tasks []DownloadTask) uint64 {
+	var left uint64
+	for _, task := range tasks {
+		size := uint64(0)
+		if stat, err := os.Stat(task.Destination); err == nil {
+			size = uint64(stat.Size())
+		}
+		if size < task.Size {
+			left += task.Size - size
+		}
+	}
+	return left
+}
+
+func createDownloadList(root string, torrent map[string]premiumize.TorrentContent, filter fileFilter, flatten bool) []DownloadTask {
 	var downloadList []DownloadTask
 
 	for _, value := range torrent {
 		if value.Type == typeFile {
-			if videoOnly && !isVideo(value) {
+			if !filter(value) {
 				continue
 			}
 
-			downloadList = append(downloadList, toDownloadTask(root, value, flatten))
+			task, err := toDownloadTask(root, value, flatten)
+			if err != nil {
+				fmt.Printf("Skipping %s: %s\n", value.Name, err.Error())
+				continue
+			}
+			downloadList = append(downloadList, task)
 		} else {
-			tasks := createDownloadList(root, value.Children, videoOnly, flatten)
+			tasks := createDownloadList(root, value.Children, filter, flatten)
 			downloadList = append(downloadList, tasks...)
 		}
 	}
 	return downloadList
 }
 
+// mediaFilter accepts video and/or audio files, or every file if neither is requested.
+func mediaFilter(videoOnly bool, audioOnly bool) fileFilter {
+	return func(file premiumize.TorrentContent) bool {
+		if !videoOnly && !audioOnly {
+			return true
+		}
+		return (videoOnly && isVideo(file)) || (audioOnly && isAudio(file))
+	}
+}
+
 func isVideo(torrentFile premiumize.TorrentContent) bool {
 	if isKnownVideoFileExtension(torrentFile.Ext) && isAllowedVideoFile(torrentFile.Name) {
 		return true
@@ -101,45 +217,76 @@ func isVideo(torrentFile premiumize.TorrentContent) bool {
 	return false
 }
 
-func toDownloadTask(root string, torrentFile premiumize.TorrentContent, flatten bool) DownloadTask {
-	path := torrentFile.Path
-	fileName := extractFileName(path)
+func isAudio(torrentFile premiumize.TorrentContent) bool {
+	return isKnownAudioFileExtension(strings.ToLower(torrentFile.Ext))
+}
 
-	destination := root + string(filepath.Separator) + fileName
-	if !flatten {
-		destination = root + string(filepath.Separator) + path
+// toDownloadTask rejects files whose path, as reported by premiumize, would
+// lead outside of root.
+func toDownloadTask(root string, torrentFile premiumize.TorrentContent, flatten bool) (DownloadTask, error) {
+	destination, err := extractionPath(root, torrentFile.Path, flatten)
+	if err != nil {
+		return DownloadTask{}, err
 	}
 
 	return DownloadTask{
 		Destination: destination,
 		URL:         torrentFile.URL,
 		Size:        uint64(torrentFile.Size),
-	}
+	}, nil
 }
 
-func downloadTask(task DownloadTask) error {
-	err := os.MkdirAll(task.Destination, 0770)
+// downloadTask fetches a single file and returns the bytes transferred. When
+// the context is done, the transfer gets the shutdown timeout to finish before
+// it is aborted. Aborted files are kept and resumed by the next download.
+func (c *Cli) downloadTask(ctx context.Context, task DownloadTask) (uint64, error) {
+	err := os.MkdirAll(filepath.Dir(task.Destination), 0770)
 	if err != nil {
 		fmt.Printf("Unable to create directory where download should be: %v", err)
 	}
 
-	respCh, err := grab.GetAsync(task.Destination, task.URL)
+	req, err := grab.NewRequest(task.URL)
 	if err != nil {
 		fmt.Printf("Error downloading %s: %s\n", task.Destination, err.Error())
-		return err
+		return 0, err
+	}
+	req.Filename = task.Destination
+
+	var resumed uint64
+	if stat, err := os.Stat(task.Destination); err == nil {
+		resumed = uint64(stat.Size())
 	}
 
-	resp := <-respCh
+	abortCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+	req.HTTPRequest = req.HTTPRequest.WithContext(abortCtx)
+
+	resp := <-grab.DefaultClient.DoAsync(req)
 	fmt.Println("")
+
+	shutdown := ctx.Done()
+	var deadline <-chan time.Time
 	for !resp.IsComplete() {
 		fmt.Printf("\033[1A   %s [%s / %s] (%d%%)\033[K\n", task.Destination, humanize.Bytes(resp.BytesTransferred()), humanize.Bytes(resp.Size), int(100*resp.Progress()))
-		time.Sleep(200 * time.Millisecond)
+
+		select {
+		case <-shutdown:
+			shutdown = nil
+			deadline = time.After(c.shutdownTimeout)
+		case <-deadline:
+			abort()
+		case <-time.After(200 * time.Millisecond):
+		}
 	}
 	fmt.Printf("\033[1A\033[K")
+	var transferred uint64
+	if total := resp.BytesTransferred(); total > resumed {
+		transferred = total - resumed
+	}
 	if resp.Error != nil {
 		fmt.Printf("   Error downloading %s: %v\n", task.URL, resp.Error)
-		return err
+		return transferred, resp.Error
 	}
 	fmt.Printf("   %s [%s]\n", task.Destination, humanize.Bytes(resp.Size))
-	return nil
+	return transferred, nil
 }
@@ -0,0 +1,431 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"os"
+	"path/filepath"
+	"pget/premiumize"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dryRunPlan describes what download, upload or watch would do. Planning only
+// reads from the disk, the database and premiumize.
+type dryRunPlan struct {
+	Uploads   []plannedUpload   `json:"uploads"`
+	Downloads []plannedDownload `json:"downloads"`
+	Skipped   []skippedItem     `json:"skipped"`
+	Deletions []plannedDeletion `json:"deletions"`
+
+	// budgeted counts the planned bytes of budgeted downloads.
+	budgeted uint64
+}
+
+type plannedUpload struct {
+	File     string `json:"file"`
+	Name     string `json:"name"`
+	Hash     string `json:"hash"`
+	Location string `json:"location,omitempty"`
+	// Queued is set for uploads already waiting in the upload queue.
+	Queued bool `json:"queued,omitempty"`
+}
+
+type plannedDownload struct {
+	ID    string         `json:"id"`
+	Name  string         `json:"name"`
+	Tasks []DownloadTask `json:"tasks"`
+}
+
+type skippedItem struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+type plannedDeletion struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+func (plan dryRunPlan) print(asJSON bool) {
+	if asJSON {
+		// Empty lists instead of null keep consumers simple
+		if plan.Uploads == nil {
+			plan.Uploads = []plannedUpload{}
+		}
+		if plan.Downloads == nil {
+			plan.Downloads = []plannedDownload{}
+		}
+		if plan.Skipped == nil {
+			plan.Skipped = []skippedItem{}
+		}
+		if plan.Deletions == nil {
+			plan.Deletions = []plannedDeletion{}
+		}
+
+		content, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			fmt.Printf("Unable to encode the plan: %s\n", err.Error())
+			return
+		}
+		fmt.Println(string(content))
+		return
+	}
+
+	if len(plan.Uploads)+len(plan.Downloads)+len(plan.Skipped)+len(plan.Deletions) == 0 {
+		fmt.Println("Nothing to do")
+		return
+	}
+
+	if len(plan.Uploads) > 0 {
+		fmt.Println("Uploads:")
+		for _, upload := range plan.Uploads {
+			name := upload.Name
+			if name == "" {
+				name = upload.Hash
+			}
+			details := "[" + upload.File + "]"
+			if upload.Location != "" {
+				details += " [" + upload.Location + "]"
+			}
+			if upload.Queued {
+				details += " [queued]"
+			}
+			fmt.Printf("* %s %s\n", name, details)
+		}
+	}
+
+	if len(plan.Downloads) > 0 {
+		fmt.Println("Downloads:")
+		for _, download := range plan.Downloads {
+			var total uint64
+			for _, task := range download.Tasks {
+				total += task.Size
+			}
+			fmt.Printf("* %s [%s]\n", download.Name, humanize.Bytes(total))
+			for _, task := range download.Tasks {
+				fmt.Printf("    %s [%s]\n", task.Destination, humanize.Bytes(task.Size))
+			}
+		}
+	}
+
+	if len(plan.Skipped) > 0 {
+		fmt.Println("Skipped:")
+		for _, skipped := range plan.Skipped {
+			fmt.Printf("* %s [%s]\n", skipped.Path, skipped.Reason)
+		}
+	}
+
+	if len(plan.Deletions) > 0 {
+		fmt.Println("Deletions:")
+		for _, deletion := range plan.Deletions {
+			fmt.Printf("* %s [%s]\n", deletion.Name, deletion.Reason)
+		}
+	}
+}
+
+// PlanDownload prints what DownloadTorrent would download.
+func (c *Cli) PlanDownload(name string, targetDirectory string, videoOnly bool, flatten bool, stopAfter string, asZip bool, asJSON bool) {
+	bytes, err := parseStopAfter(stopAfter)
+	if err != nil {
+		fmt.Printf("Unable to parse %s. Error: %s\n", stopAfter, err.Error())
+	}
+
+	transfer, err := c.premiumize.FindTorrentByName(name)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	var plan dryRunPlan
+	c.planTransfer(&plan, transfer, targetDirectory, mediaFilter(videoOnly, false), flatten, asZip, downloadLimit{stopAfter: bytes})
+	plan.print(asJSON)
+}
+
+// PlanUpload prints what Upload would upload.
+func (c *Cli) PlanUpload(link string, asJSON bool) {
+	var plan dryRunPlan
+
+	hash, err := linkInfoHash(link)
+	if err != nil {
+		plan.Skipped = append(plan.Skipped, skippedItem{Path: link, Reason: err.Error()})
+	} else {
+		name := ""
+		if !strings.HasPrefix(link, magnetPrefix) {
+			name = uploadName(link)
+		}
+		plan.Uploads = append(plan.Uploads, plannedUpload{File: link, Name: name, Hash: hash})
+	}
+	plan.print(asJSON)
+}
+
+// PlanWatch prints what a single cycle of watch would do: the torrent files in
+// the upload directory and the queue that would be uploaded, the finished
+// transfers that would be downloaded and the transfers that would be deleted.
+// An empty directory disables that side.
+func (c *Cli) PlanWatch(uploadDirectory string, onlyCached bool, config DownloadWatchConfig, asJSON bool) {
+	var plan dryRunPlan
+
+	if uploadDirectory != "" {
+		c.planUploads(&plan, uploadDirectory, onlyCached)
+	}
+
+	if config.Directory != "" {
+		torrents, err := c.premiumize.ListTorrents()
+		if err != nil {
+			fmt.Printf("Could not retrieve list of torrents: %s\n", err.Error())
+			return
+		}
+		pending := c.planDownloads(&plan, torrents.Transfers, config)
+		c.planDeletions(&plan, torrents.Transfers, pending)
+	}
+
+	plan.print(asJSON)
+}
+
+func (c *Cli) planUploads(plan *dryRunPlan, directory string, onlyCached bool) {
+	pattern := regexp.MustCompile(torrentFilePattern)
+	pending := regexp.MustCompile(pendingPattern(directory))
+
+	var files []string
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if pending.MatchString(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if pattern.MatchString(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Unable to read %s: %s\n", directory, err.Error())
+	}
+
+	hashes := make(map[string]string)
+	var cached map[string]premiumize.HashStatus
+	for _, file := range files {
+		hash, err := infoHash(file)
+		if err != nil {
+			plan.Skipped = append(plan.Skipped, skippedItem{Path: file, Reason: err.Error()})
+			continue
+		}
+		hashes[file] = hash
+	}
+
+	if onlyCached && len(hashes) > 0 {
+		var all []string
+		for _, hash := range hashes {
+			all = append(all, hash)
+		}
+		if cached, err = c.checkHashes(all); err != nil {
+			fmt.Printf("Unable to check cache: %s\n", err.Error())
+			return
+		}
+	}
+
+	for _, file := range files {
+		hash, ok := hashes[file]
+		if !ok {
+			continue
+		}
+		if _, ok := cached[hash]; onlyCached && !ok {
+			plan.Skipped = append(plan.Skipped, skippedItem{Path: file, Reason: "not cached, moved to pending"})
+			continue
+		}
+		plan.Uploads = append(plan.Uploads, plannedUpload{
+			File:     file,
+			Name:     uploadName(file),
+			Hash:     hash,
+			Location: extractLocation(directory, file),
+		})
+	}
+
+	entries, err := c.queuedUploads()
+	if err != nil {
+		fmt.Printf("Could not read upload queue: %s\n", err.Error())
+		return
+	}
+	for _, entry := range entries {
+		// Queued files are archived by their info hash
+		plan.Uploads = append(plan.Uploads, plannedUpload{
+			File:     entry.File,
+			Name:     entry.Name,
+			Hash:     uploadName(entry.File),
+			Location: entry.Location,
+			Queued:   true,
+		})
+	}
+}
+
+// planDownloads plans the finished transfers in download order, within the
+// remaining download budget. Like watch, it stops at the first transfer which
+// does not fit into the budget. It returns the transfers which would stay
+// pending.
+func (c *Cli) planDownloads(plan *dryRunPlan, transfers []premiumize.TorrentItem, config DownloadWatchConfig) map[string]premiumize.TorrentItem {
+	pending := make(map[string]premiumize.TorrentItem)
+	var finished []premiumize.TorrentItem
+	for _, transfer := range transfers {
+		if c.isTorrentFinished(transfer.Status) && !c.isDownloaded(transfer.ID) && !c.isDeletionScheduled(transfer.ID) {
+			finished = append(finished, transfer)
+		}
+	}
+
+	budget, limited := c.remainingBudget()
+	budgetExceeded := limited && budget == 0
+	windowOpen := c.isDownloadWindowOpen()
+
+	for _, transfer := range c.orderTransfers(finished, transfers, c.seenBefore(transfers), config.Defaults) {
+		location := c.uploadLocation(transfer)
+		category := c.categoryFor(location, config.Defaults)
+
+		switch {
+		case !c.hasBeenUploadedWhenStrict(config.Strict, transfer):
+			plan.Skipped = append(plan.Skipped, skippedItem{Path: transfer.Name, Reason: "not uploaded by pget"})
+		case category.Manual:
+			plan.Skipped = append(plan.Skipped, skippedItem{Path: transfer.Name, Reason: "manual category"})
+		case !windowOpen:
+			plan.Skipped = append(plan.Skipped, skippedItem{Path: transfer.Name, Reason: "outside of the download windows"})
+			pending[transfer.ID] = transfer
+		case budgetExceeded:
+			plan.Skipped = append(plan.Skipped, skippedItem{Path: transfer.Name, Reason: "download budget used up"})
+			pending[transfer.ID] = transfer
+		default:
+			err := c.planTransfer(plan, transfer, category.directory(config.Directory, location), category.filter(), category.Flatten, category.AsZip, downloadLimit{budgeted: true})
+			if err == errBudgetExceeded {
+				budgetExceeded = true
+			}
+			if err != nil {
+				pending[transfer.ID] = transfer
+			} else if category.DeleteDownloaded {
+				plan.Deletions = append(plan.Deletions, plannedDeletion{
+					ID:     transfer.ID,
+					Name:   transfer.Name,
+					Reason: fmt.Sprintf("downloaded, after %s", config.DeleteGracePeriod),
+				})
+			}
+		}
+	}
+	return pending
+}
+
+// planTransfer adds the download tasks of a transfer to the plan. Like
+// download, it stops at the first new file that would exceed the stopAfter
+// limit, returning errLimitReached, and defers budgeted transfers which do not
+// fit into the budget as a whole, returning errBudgetExceeded.
+func (c *Cli) planTransfer(plan *dryRunPlan, transfer premiumize.TorrentItem, targetDirectory string, filter fileFilter, flatten bool, asZip bool, limit downloadLimit) error {
+	torrent, err := c.premiumize.BrowseTorrent(transfer.Hash)
+	if err != nil {
+		plan.Skipped = append(plan.Skipped, skippedItem{Path: transfer.Name, Reason: err.Error()})
+		return err
+	}
+
+	var tasks []DownloadTask
+	if asZip {
+		if torrent.Zip == "" {
+			err := fmt.Errorf("no zip archive available")
+			plan.Skipped = append(plan.Skipped, skippedItem{Path: transfer.Name, Reason: err.Error()})
+			return err
+		}
+		tasks = []DownloadTask{{
+			Destination: filepath.Join(targetDirectory, strings.Replace(transfer.Name, "/", "_", -1)+".zip"),
+			URL:         torrent.Zip,
+			Size:        uint64(torrent.Size),
+		}}
+	} else {
+		tasks = createDownloadList(targetDirectory, torrent.Content, filter, flatten)
+
+		included := make(map[string]bool)
+		for _, task := range tasks {
+			included[task.Destination] = true
+		}
+		for _, task := range createDownloadList(targetDirectory, torrent.Content, mediaFilter(false, false), flatten) {
+			if !included[task.Destination] {
+				plan.Skipped = append(plan.Skipped, skippedItem{Path: task.Destination, Reason: "filtered"})
+			}
+		}
+	}
+	c.orderTasks(tasks)
+
+	if limit.budgeted {
+		if !c.budgetAllows(bytesLeft(tasks), plan.budgeted) {
+			plan.Skipped = append(plan.Skipped, skippedItem{Path: transfer.Name, Reason: "over the download budget"})
+			return errBudgetExceeded
+		}
+		planned, _ := planTasks(plan, transfer, tasks, 0)
+		plan.budgeted += planned
+		return nil
+	}
+
+	if _, complete := planTasks(plan, transfer, tasks, limit.stopAfter); !complete {
+		return errLimitReached
+	}
+	return nil
+}
+
+// planTasks adds the tasks of a transfer which are not downloaded yet to the
+// plan, see planTransfer.
+func planTasks(plan *dryRunPlan, transfer premiumize.TorrentItem, tasks []DownloadTask, stopAfterBytes uint64) (uint64, bool) {
+	download := plannedDownload{ID: transfer.ID, Name: transfer.Name}
+	var planned, newBytes uint64
+	limitReached := false
+	for _, task := range tasks {
+		stat, err := os.Stat(task.Destination)
+		exists := err == nil
+		if !exists {
+			newBytes += task.Size
+		}
+
+		switch {
+		case exists && uint64(stat.Size()) == task.Size:
+			plan.Skipped = append(plan.Skipped, skippedItem{Path: task.Destination, Reason: "already downloaded"})
+		case limitReached || (stopAfterBytes != 0 && newBytes > stopAfterBytes):
+			limitReached = true
+			plan.Skipped = append(plan.Skipped, skippedItem{Path: task.Destination, Reason: "over the " + humanize.Bytes(stopAfterBytes) + " limit"})
+		case exists && uint64(stat.Size()) < task.Size:
+			download.Tasks = append(download.Tasks, task)
+			planned += task.Size - uint64(stat.Size())
+		default:
+			download.Tasks = append(download.Tasks, task)
+			planned += task.Size
+		}
+	}
+
+	if len(download.Tasks) > 0 {
+		plan.Downloads = append(plan.Downloads, download)
+	}
+	return planned, !limitReached
+}
+
+// planDeletions adds the due scheduled deletions and the cleanup candidates,
+// leaving out the pending transfers like watch does.
+func (c *Cli) planDeletions(plan *dryRunPlan, transfers []premiumize.TorrentItem, pending map[string]premiumize.TorrentItem) {
+	existing := make(map[string]bool)
+	for _, transfer := range transfers {
+		existing[transfer.ID] = true
+	}
+
+	for _, deletion := range c.scheduledDeletions() {
+		if existing[deletion.ID] && !deletion.Cancelled && !time.Now().Before(deletion.Due) {
+			plan.Deletions = append(plan.Deletions, plannedDeletion{ID: deletion.ID, Name: deletion.Name, Reason: "grace period over"})
+		}
+	}
+
+	if c.cleanupConfig.enabled() {
+		for _, candidate := range c.cleanupCandidates(transfers, c.seenBefore(transfers), pending) {
+			plan.Deletions = append(plan.Deletions, plannedDeletion{
+				ID:     candidate.Transfer.ID,
+				Name:   candidate.Transfer.Name,
+				Reason: "cleanup, " + candidate.Reason,
+			})
+		}
+	}
+}
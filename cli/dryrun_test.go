@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"pget/premiumize"
+	"reflect"
+	"testing"
+)
+
+func TestPlanTasks(t *testing.T) {
+	directory, err := ioutil.TempDir("", "pget-plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	for name, size := range map[string]int{"done.mkv": 10, "partial.mkv": 4} {
+		if err := ioutil.WriteFile(filepath.Join(directory, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tasks []DownloadTask
+	for _, name := range []string{"done.mkv", "partial.mkv", "new1.mkv", "new2.mkv"} {
+		tasks = append(tasks, DownloadTask{Destination: filepath.Join(directory, name), Size: 10})
+	}
+
+	tests := []struct {
+		description string
+		tasks       []DownloadTask
+		stopAfter   uint64
+		planned     uint64
+		complete    bool
+		downloads   []string
+		skipped     []string
+	}{
+		{
+			description: "no limit",
+			tasks:       tasks,
+			planned:     26,
+			complete:    true,
+			downloads:   []string{"partial.mkv", "new1.mkv", "new2.mkv"},
+			skipped:     []string{"done.mkv: already downloaded"},
+		},
+		{
+			description: "limit after the first new file",
+			tasks:       tasks,
+			stopAfter:   15,
+			planned:     16,
+			downloads:   []string{"partial.mkv", "new1.mkv"},
+			skipped:     []string{"done.mkv: already downloaded", "new2.mkv: over the 15 B limit"},
+		},
+		{
+			description: "limit below the first new file",
+			tasks:       tasks,
+			stopAfter:   5,
+			planned:     6,
+			downloads:   []string{"partial.mkv"},
+			skipped:     []string{"done.mkv: already downloaded", "new1.mkv: over the 5 B limit", "new2.mkv: over the 5 B limit"},
+		},
+		{
+			description: "already downloaded",
+			tasks:       tasks[:1],
+			complete:    true,
+			skipped:     []string{"done.mkv: already downloaded"},
+		},
+	}
+
+	for _, test := range tests {
+		var plan dryRunPlan
+		planned, complete := planTasks(&plan, premiumize.TorrentItem{ID: "id", Name: "movie"}, test.tasks, test.stopAfter)
+		if planned != test.planned || complete != test.complete {
+			t.Errorf("%s: planned %d, %v, want %d, %v", test.description, planned, complete, test.planned, test.complete)
+		}
+
+		var downloads []string
+		for _, download := range plan.Downloads {
+			for _, task := range download.Tasks {
+				downloads = append(downloads, filepath.Base(task.Destination))
+			}
+		}
+		if len(plan.Downloads) > 1 {
+			t.Errorf("%s: %d downloads planned for one transfer", test.description, len(plan.Downloads))
+		}
+		if !reflect.DeepEqual(downloads, test.downloads) {
+			t.Errorf("%s: downloads %v, want %v", test.description, downloads, test.downloads)
+		}
+
+		var skipped []string
+		for _, item := range plan.Skipped {
+			skipped = append(skipped, filepath.Base(item.Path)+": "+item.Reason)
+		}
+		if !reflect.DeepEqual(skipped, test.skipped) {
+			t.Errorf("%s: skipped %v, want %v", test.description, skipped, test.skipped)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package cli
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"pget/premiumize"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var archivePattern = regexp.MustCompile(`(?i)\.(zip|tar|tar\.gz|tgz|tar\.bz2|tbz2)$`)
+var archivePartPattern = regexp.MustCompile(`(?i)^(.+\.(?:zip|tar|tar\.gz|tgz|tar\.bz2|tbz2))\.(\d{3})$`)
+
+// archive is a downloaded archive, which is either a single file or a set of
+// parts (name.zip.001, name.zip.002, ...) that have to be joined in order.
+type archive struct {
+	Name  string
+	Parts []string
+}
+
+// findArchives returns the archives among the downloaded files.
+func findArchives(tasks []DownloadTask) []archive {
+	parts := make(map[string][]string)
+	var names []string
+	for _, task := range tasks {
+		name := task.Destination
+		if match := archivePartPattern.FindStringSubmatch(name); match != nil {
+			name = match[1]
+		} else if !archivePattern.MatchString(name) {
+			continue
+		}
+
+		if _, ok := parts[name]; !ok {
+			names = append(names, name)
+		}
+		parts[name] = append(parts[name], task.Destination)
+	}
+
+	sort.Strings(names)
+	var archives []archive
+	for _, name := range names {
+		sort.Strings(parts[name])
+		archives = append(archives, archive{Name: name, Parts: parts[name]})
+	}
+	return archives
+}
+
+// checkParts makes sure a multi-part archive is complete, starting at part 001
+// without any gaps.
+func (a archive) checkParts() error {
+	if len(a.Parts) == 1 && a.Parts[0] == a.Name {
+		return nil
+	}
+
+	for i, part := range a.Parts {
+		match := archivePartPattern.FindStringSubmatch(part)
+		if match == nil {
+			return fmt.Errorf("%s mixes single and multi-part files", a.Name)
+		}
+		if number, _ := strconv.Atoi(match[2]); number != i+1 {
+			return fmt.Errorf("Part %03d of %s is missing", i+1, a.Name)
+		}
+	}
+	return nil
+}
+
+// directory returns where the archive is extracted to, either next to the
+// archive or into a subfolder named after it.
+func (a archive) directory(subfolder bool) string {
+	directory := filepath.Dir(a.Name)
+	if !subfolder {
+		return directory
+	}
+	return filepath.Join(directory, archivePattern.ReplaceAllString(filepath.Base(a.Name), ""))
+}
+
+// extractArchives extracts the archives among the downloaded files of a
+// transfer and returns the extracted files. Archives are only deleted once all
+// of them have been extracted successfully.
+func extractArchives(result downloadResult, category Category) ([]string, error) {
+	var extracted []string
+	var failed []string
+	archives := findArchives(result.Tasks)
+	for _, a := range archives {
+		files, err := extractArchive(a, a.directory(category.ExtractSubfolder), category.extractFilter(), false)
+		extracted = append(extracted, files...)
+		if err != nil {
+			fmt.Printf("Unable to extract %s: %s\n", a.Name, err.Error())
+			failed = append(failed, filepath.Base(a.Name))
+			continue
+		}
+		fmt.Printf("Extracted %d files from %s\n", len(files), a.Name)
+	}
+
+	if len(failed) > 0 {
+		return extracted, fmt.Errorf("Extraction of %s failed", strings.Join(failed, ", "))
+	}
+
+	if category.DeleteArchives {
+		for _, a := range archives {
+			for _, part := range a.Parts {
+				if err := os.Remove(part); err != nil {
+					fmt.Printf("Could not delete archive %s: %s\n", part, err.Error())
+				}
+			}
+		}
+	}
+	return extracted, nil
+}
+
+// extractArchive extracts the files accepted by the filter into directory,
+// dropping their folders when flattening. Every file is checked against the
+// size (and for zip the checksum) recorded in the archive.
+func extractArchive(a archive, directory string, filter fileFilter, flatten bool) ([]string, error) {
+	if err := a.checkParts(); err != nil {
+		return nil, err
+	}
+
+	reader, err := openParts(a.Parts)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	name := strings.ToLower(a.Name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return extractZip(reader, directory, filter, flatten)
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		return extractTar(gzipReader, directory, filter, flatten)
+	case strings.HasSuffix(name, ".tar.bz2") || strings.HasSuffix(name, ".tbz2"):
+		return extractTar(bzip2.NewReader(reader), directory, filter, flatten)
+	default:
+		return extractTar(reader, directory, filter, flatten)
+	}
+}
+
+func extractZip(reader *partsReader, directory string, filter fileFilter, flatten bool) ([]string, error) {
+	zipReader, err := zip.NewReader(reader, reader.size)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, file := range zipReader.File {
+		if !file.Mode().IsRegular() || !filter(archiveEntry(file.Name, int64(file.UncompressedSize64))) {
+			continue
+		}
+
+		target, err := extractionPath(directory, file.Name, flatten)
+		if err != nil {
+			return files, err
+		}
+
+		content, err := file.Open()
+		if err != nil {
+			return files, err
+		}
+		err = writeExtractedFile(target, content, int64(file.UncompressedSize64))
+		content.Close()
+		if err != nil {
+			return files, err
+		}
+		files = append(files, target)
+	}
+	return files, nil
+}
+
+func extractTar(reader io.Reader, directory string, filter fileFilter, flatten bool) ([]string, error) {
+	tarReader := tar.NewReader(reader)
+
+	var files []string
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, err
+		}
+
+		// Links are skipped, they could point outside of the directory.
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if !filter(archiveEntry(header.Name, header.Size)) {
+			continue
+		}
+
+		target, err := extractionPath(directory, header.Name, flatten)
+		if err != nil {
+			return files, err
+		}
+		if err := writeExtractedFile(target, tarReader, header.Size); err != nil {
+			return files, err
+		}
+		files = append(files, target)
+	}
+}
+
+// archiveEntry describes an archive entry like a transfer file, so the file
+// filters apply to both.
+func archiveEntry(name string, size int64) premiumize.TorrentContent {
+	path := strings.Trim(strings.Replace(name, "\\", "/", -1), "/")
+	fileName := extractFileName(path)
+	return premiumize.TorrentContent{
+		Type: typeFile,
+		Name: fileName,
+		Size: size,
+		Ext:  strings.TrimPrefix(strings.ToLower(filepath.Ext(fileName)), "."),
+		Path: path,
+	}
+}
+
+// extractionPath returns where an archive entry or a downloaded file is
+// written to. Paths which would end up outside of the directory (zip slip) are
+// rejected.
+func extractionPath(directory string, name string, flatten bool) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(strings.Replace(name, "\\", "/", -1)))
+	if filepath.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Illegal path %s", name)
+	}
+	if flatten {
+		cleaned = filepath.Base(cleaned)
+	}
+	return filepath.Join(directory, cleaned), nil
+}
+
+func writeExtractedFile(target string, content io.Reader, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0770); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
+	if err != nil {
+		return err
+	}
+
+	written, err := io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("%s has %d bytes, expected %d", target, written, size)
+	}
+	return nil
+}
+
+// partsReader reads the parts of a multi-part archive as one file.
+type partsReader struct {
+	files  []*os.File
+	sizes  []int64
+	size   int64
+	offset int64
+}
+
+func openParts(paths []string) (*partsReader, error) {
+	reader := &partsReader{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		reader.files = append(reader.files, file)
+
+		stat, err := file.Stat()
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		reader.sizes = append(reader.sizes, stat.Size())
+		reader.size += stat.Size()
+	}
+	return reader, nil
+}
+
+func (r *partsReader) ReadAt(p []byte, offset int64) (int, error) {
+	read := 0
+	for len(p) > 0 {
+		if offset >= r.size {
+			return read, io.EOF
+		}
+
+		index, start := 0, int64(0)
+		for offset >= start+r.sizes[index] {
+			start += r.sizes[index]
+			index++
+		}
+
+		chunk := p
+		if remaining := start + r.sizes[index] - offset; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+
+		n, err := r.files[index].ReadAt(chunk, offset-start)
+		read += n
+		offset += int64(n)
+		p = p[n:]
+		if n < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *partsReader) Close() error {
+	for _, file := range r.files {
+		file.Close()
+	}
+	return nil
+}
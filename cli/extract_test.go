@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestExtractionPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		flatten bool
+		want    string
+		wantErr bool
+	}{
+		{name: "movie.mkv", want: "target/movie.mkv"},
+		{name: "folder/movie.mkv", want: "target/folder/movie.mkv"},
+		{name: "folder/movie.mkv", flatten: true, want: "target/movie.mkv"},
+		{name: "folder\\sub\\movie.mkv", want: "target/folder/sub/movie.mkv"},
+		{name: "folder/../movie.mkv", want: "target/movie.mkv"},
+		{name: "./movie.mkv", want: "target/movie.mkv"},
+		{name: "../movie.mkv", wantErr: true},
+		{name: "folder/../../movie.mkv", wantErr: true},
+		{name: "..\\movie.mkv", wantErr: true},
+		{name: "../movie.mkv", flatten: true, wantErr: true},
+		{name: "/etc/passwd", wantErr: true},
+		{name: "..", wantErr: true},
+		{name: ".", wantErr: true},
+		{name: "", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := extractionPath("target", test.name, test.flatten)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("extractionPath(%q, %v) = %q, want an error", test.name, test.flatten, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("extractionPath(%q, %v) failed: %s", test.name, test.flatten, err)
+			continue
+		}
+		if want := filepath.FromSlash(test.want); got != want {
+			t.Errorf("extractionPath(%q, %v) = %q, want %q", test.name, test.flatten, got, want)
+		}
+	}
+}
+
+func TestExtractArchives(t *testing.T) {
+	movie := []byte("movie")
+	sample := []byte("sample")
+
+	tests := []struct {
+		description string
+		archives    map[string][]byte
+		category    Category
+		want        []string
+		remaining   []string
+		wantErr     bool
+	}{
+		{
+			description: "zip next to the archive",
+			archives: map[string][]byte{
+				"movie.zip": zipArchive(t, map[string][]byte{"movie.mkv": movie, "extra/sample.mkv": sample}),
+			},
+			category:  Category{Extract: true},
+			want:      []string{"extra/sample.mkv", "movie.mkv"},
+			remaining: []string{"extra/sample.mkv", "movie.mkv", "movie.zip"},
+		},
+		{
+			description: "tar.gz into a subfolder and delete the archive",
+			archives: map[string][]byte{
+				"movie.tar.gz": tarGzArchive(t, map[string][]byte{"movie.mkv": movie}),
+			},
+			category:  Category{Extract: true, ExtractSubfolder: true, DeleteArchives: true},
+			want:      []string{"movie/movie.mkv"},
+			remaining: []string{"movie/movie.mkv"},
+		},
+		{
+			description: "extract only matching files",
+			archives: map[string][]byte{
+				"movie.zip": zipArchive(t, map[string][]byte{"movie.mkv": movie, "info.nfo": sample}),
+			},
+			category:  Category{Extract: true, ExtractOnly: []string{"*.MKV"}},
+			want:      []string{"movie.mkv"},
+			remaining: []string{"movie.mkv", "movie.zip"},
+		},
+		{
+			description: "multi-part zip",
+			archives: splitArchive(zipArchive(t, map[string][]byte{
+				"movie.mkv": bytes.Repeat(movie, 100),
+			}), "movie.zip", 3),
+			category:  Category{Extract: true, DeleteArchives: true},
+			want:      []string{"movie.mkv"},
+			remaining: []string{"movie.mkv"},
+		},
+		{
+			description: "multi-part zip with a missing part",
+			archives: func() map[string][]byte {
+				parts := splitArchive(zipArchive(t, map[string][]byte{"movie.mkv": movie}), "movie.zip", 3)
+				delete(parts, "movie.zip.002")
+				return parts
+			}(),
+			category:  Category{Extract: true, DeleteArchives: true},
+			remaining: []string{"movie.zip.001", "movie.zip.003"},
+			wantErr:   true,
+		},
+		{
+			description: "zip slip",
+			archives: map[string][]byte{
+				"evil.zip": zipArchive(t, map[string][]byte{"../evil.sh": sample}),
+			},
+			category:  Category{Extract: true, DeleteArchives: true},
+			remaining: []string{"evil.zip"},
+			wantErr:   true,
+		},
+		{
+			description: "tar slip",
+			archives: map[string][]byte{
+				"evil.tar.gz": tarGzArchive(t, map[string][]byte{"/tmp/evil.sh": sample}),
+			},
+			category:  Category{Extract: true, DeleteArchives: true},
+			remaining: []string{"evil.tar.gz"},
+			wantErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		directory, err := ioutil.TempDir("", "pget-extract")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var result downloadResult
+		for name, content := range test.archives {
+			path := filepath.Join(directory, name)
+			if err := ioutil.WriteFile(path, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			result.Tasks = append(result.Tasks, DownloadTask{Destination: path})
+		}
+
+		extracted, err := extractArchives(result, test.category)
+		if test.wantErr != (err != nil) {
+			t.Errorf("%s: unexpected error %v", test.description, err)
+		}
+		if got := relativePaths(t, directory, extracted); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: extracted %v, want %v", test.description, got, test.want)
+		}
+		if got := listFiles(t, directory); !reflect.DeepEqual(got, test.remaining) {
+			t.Errorf("%s: directory contains %v, want %v", test.description, got, test.remaining)
+		}
+
+		os.RemoveAll(directory)
+	}
+}
+
+func zipArchive(t *testing.T, files map[string][]byte) []byte {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for _, name := range sortedNames(files) {
+		file, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write(files[name])
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func tarGzArchive(t *testing.T, files map[string][]byte) []byte {
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	writer := tar.NewWriter(gzipWriter)
+	for _, name := range sortedNames(files) {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		writer.Write(files[name])
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// splitArchive splits content into name.001, name.002, ...
+func splitArchive(content []byte, name string, count int) map[string][]byte {
+	parts := make(map[string][]byte)
+	size := (len(content) + count - 1) / count
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(content) {
+			end = len(content)
+		}
+		parts[fmt.Sprintf("%s.%03d", name, i+1)] = content[i*size : end]
+	}
+	return parts
+}
+
+func sortedNames(files map[string][]byte) []string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
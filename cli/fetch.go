@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"pget/premiumize"
+	"strings"
+)
+
+func (c *Cli) Fetch(links []string, targetDirectory string, videoOnly bool, flatten bool, stopAfter string) error {
+	bytes, err := parseStopAfter(stopAfter)
+	if err != nil {
+		fmt.Printf("Unable to parse %s. Error: %s\n", stopAfter, err.Error())
+	}
+
+	var tasks []DownloadTask
+	for _, link := range links {
+		directDownload, err := c.premiumize.DirectDownload(link)
+		if err != nil {
+			fmt.Printf("Unable to resolve %s: %s\n", link, err.Error())
+			continue
+		}
+
+		content := directDownloadContent(directDownload)
+		if len(content) == 0 {
+			fmt.Printf("No files found for %s\n", link)
+			continue
+		}
+
+		tasks = append(tasks, createDownloadList(targetDirectory, content, mediaFilter(videoOnly, false), flatten)...)
+	}
+
+	return c.download(context.Background(), tasks, downloadLimit{stopAfter: bytes})
+}
+
+// directDownloadContent maps the resolved hoster files onto the torrent content
+// structure, so the same filters and layout rules apply to both.
+func directDownloadContent(directDownload premiumize.DirectDownload) map[string]premiumize.TorrentContent {
+	files := directDownload.Content
+	if len(files) == 0 && directDownload.Location != "" {
+		files = []premiumize.DirectDownloadContent{{
+			Path: directDownload.Filename,
+			Size: directDownload.Filesize,
+			Link: directDownload.Location,
+		}}
+	}
+
+	content := make(map[string]premiumize.TorrentContent)
+	for _, file := range files {
+		path := strings.TrimPrefix(filepath.ToSlash(file.Path), "/")
+		name := extractFileName(path)
+		content[path] = premiumize.TorrentContent{
+			Type: typeFile,
+			Name: name,
+			Size: file.Size,
+			Ext:  strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), "."),
+			URL:  file.Link,
+			Path: path,
+		}
+	}
+	return content
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"pget/premiumize"
+	"reflect"
+	"testing"
+)
+
+func TestDirectDownloadTasks(t *testing.T) {
+	tests := []struct {
+		description    string
+		directDownload premiumize.DirectDownload
+		flatten        bool
+		want           []string
+	}{
+		{
+			description:    "single file",
+			directDownload: premiumize.DirectDownload{Location: "https://example.com/1", Filename: "movie.mkv", Filesize: 10},
+			want:           []string{"movie.mkv"},
+		},
+		{
+			description: "folder",
+			directDownload: premiumize.DirectDownload{Content: []premiumize.DirectDownloadContent{
+				{Path: "movie/movie.mkv", Link: "https://example.com/1"},
+				{Path: "/movie/extra/sample.mkv", Link: "https://example.com/2"},
+			}},
+			want: []string{"movie/extra/sample.mkv", "movie/movie.mkv"},
+		},
+		{
+			description: "flattened folder",
+			directDownload: premiumize.DirectDownload{Content: []premiumize.DirectDownloadContent{
+				{Path: "movie/movie.mkv", Link: "https://example.com/1"},
+				{Path: "movie/extra/sample.mkv", Link: "https://example.com/2"},
+			}},
+			flatten: true,
+			want:    []string{"movie.mkv", "sample.mkv"},
+		},
+		{
+			description: "paths leading outside",
+			directDownload: premiumize.DirectDownload{Content: []premiumize.DirectDownloadContent{
+				{Path: "movie/movie.mkv", Link: "https://example.com/1"},
+				{Path: "../../.bashrc", Link: "https://example.com/2"},
+				{Path: "movie/../../evil.sh", Link: "https://example.com/3"},
+				{Path: "..\\evil.exe", Link: "https://example.com/4"},
+				{Path: "..", Link: "https://example.com/5"},
+			}},
+			want: []string{"movie/movie.mkv"},
+		},
+		{
+			description:    "file name leading outside",
+			directDownload: premiumize.DirectDownload{Location: "https://example.com/1", Filename: "../evil.sh"},
+			flatten:        true,
+		},
+	}
+
+	for _, test := range tests {
+		content := directDownloadContent(test.directDownload)
+		var destinations []string
+		for _, task := range createDownloadList("target", content, mediaFilter(false, false), test.flatten) {
+			destinations = append(destinations, task.Destination)
+		}
+		if got := relativePaths(t, "target", destinations); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: downloads %v, want %v", test.description, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// inTempDir runs the test in an empty directory, so that pget.db is created
+// there.
+func inTempDir(t *testing.T) func() {
+	directory, err := ioutil.TempDir("", "pget-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(directory); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		os.Chdir(previous)
+		os.RemoveAll(directory)
+	}
+}
+
+func relativePaths(t *testing.T, directory string, paths []string) []string {
+	var relative []string
+	for _, path := range paths {
+		rel, err := filepath.Rel(directory, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		relative = append(relative, filepath.ToSlash(rel))
+	}
+	sort.Strings(relative)
+	return relative
+}
+
+func listFiles(t *testing.T, directory string) []string {
+	var files []string
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return relativePaths(t, directory, files)
+}
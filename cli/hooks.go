@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const hookUpload = "on_upload"
+const hookTransferFinished = "on_transfer_finished"
+const hookDownloadComplete = "on_download_complete"
+const hookError = "on_error"
+const hookStalled = "on_stalled"
+
+const hooksBucket = "hooks"
+const finishedBucket = "finished"
+const defaultHookTimeout = 10 * time.Minute
+
+// Hook is an external command run through the shell when an event occurs.
+type Hook struct {
+	Command string `mapstructure:"command"`
+	// Timeout after which the command is killed, defaults to 10 minutes.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Concurrency limits how many instances of the command run at the same
+	// time, defaults to 1.
+	Concurrency int `mapstructure:"concurrency"`
+}
+
+// hookEnv describes the transfer a hook runs for. It is passed to the command
+// as PGET_* environment variables.
+type hookEnv struct {
+	ID    string
+	Name  string
+	Hash  string
+	Dir   string
+	Files []string
+	Error string
+}
+
+// hookRun is the outcome of a hook as recorded in the database.
+type hookRun struct {
+	Event    string    `json:"event"`
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Command  string    `json:"command"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	ExitCode int       `json:"exit_code"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// SetHooks sets the hooks used for transfers without category specific hooks.
+func (c *Cli) SetHooks(hooks map[string]Hook) {
+	c.hooks = hooks
+}
+
+// hooksFor returns the global hooks, overridden by the hooks of the category.
+func (c *Cli) hooksFor(category Category) map[string]Hook {
+	hooks := make(map[string]Hook)
+	for event, hook := range c.hooks {
+		hooks[event] = hook
+	}
+	for event, hook := range category.Hooks {
+		hooks[event] = hook
+	}
+	return hooks
+}
+
+// runHook starts the hook of an event in the background. Close waits for
+// running hooks.
+func (c *Cli) runHook(hooks map[string]Hook, event string, env hookEnv) {
+	hook, ok := hooks[event]
+	if !ok || hook.Command == "" {
+		return
+	}
+
+	slots := c.hookSlots(event, hook)
+
+	c.hooksRunning.Add(1)
+	go func() {
+		defer c.hooksRunning.Done()
+
+		slots <- struct{}{}
+		defer func() { <-slots }()
+
+		run := execHook(hook, event, env)
+		if run.Error != "" {
+			fmt.Printf("Hook %s for %s failed: %s\n", event, env.Name, run.Error)
+		}
+		if err := c.putJSON(hooksBucket, run.Started.Format(time.RFC3339Nano)+" "+event+" "+env.ID, run); err != nil {
+			fmt.Printf("Could not record hook %s for %s: %s\n", event, env.Name, err.Error())
+		}
+	}()
+}
+
+// hookSlots returns the semaphore limiting the concurrency of a hook.
+func (c *Cli) hookSlots(event string, hook Hook) chan struct{} {
+	c.hookSlotsMutex.Lock()
+	defer c.hookSlotsMutex.Unlock()
+
+	if c.hookSlotsByCommand == nil {
+		c.hookSlotsByCommand = make(map[string]chan struct{})
+	}
+
+	key := event + " " + hook.Command
+	slots, ok := c.hookSlotsByCommand[key]
+	if !ok {
+		concurrency := hook.Concurrency
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		slots = make(chan struct{}, concurrency)
+		c.hookSlotsByCommand[key] = slots
+	}
+	return slots
+}
+
+func execHook(hook Hook, event string, env hookEnv) hookRun {
+	timeout := hook.Timeout
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"PGET_EVENT="+event,
+		"PGET_ID="+env.ID,
+		"PGET_NAME="+env.Name,
+		"PGET_HASH="+env.Hash,
+		"PGET_DIR="+env.Dir,
+		"PGET_FILES="+strings.Join(env.Files, "\n"),
+		"PGET_ERROR="+env.Error,
+	)
+
+	run := hookRun{
+		Event:   event,
+		ID:      env.ID,
+		Name:    env.Name,
+		Command: hook.Command,
+		Started: time.Now(),
+	}
+
+	err := cmd.Run()
+	run.Finished = time.Now()
+	run.ExitCode = -1
+	if cmd.ProcessState != nil {
+		run.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		run.Error = fmt.Sprintf("timed out after %s", timeout)
+	} else if err != nil {
+		run.Error = err.Error()
+	}
+	return run
+}
+
+// markTransferFinished records that a transfer has been seen finished and
+// returns false if it had been recorded before.
+func (c *Cli) markTransferFinished(id string) bool {
+	var finished time.Time
+	if found, err := c.getJSON(finishedBucket, id, &finished); err != nil || found {
+		return false
+	}
+
+	if err := c.putJSON(finishedBucket, id, time.Now()); err != nil {
+		fmt.Printf("Could not record finished transfer %s: %s\n", id, err.Error())
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const lockFileName = ".pget.lock"
+
+// lockFile is an exclusive lock on a file containing the PID of the owning
+// process. acquireLock and Release are implemented per platform.
+type lockFile struct {
+	path string
+	file *os.File
+}
+
+func writeLockPID(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+	return err
+}
+
+func readLockPID(path string) (int, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
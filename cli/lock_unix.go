@@ -0,0 +1,70 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// acquireLock takes an exclusive flock on the file at path, creating it if
+// necessary. The kernel drops the lock when the process exits, so a lock file
+// left behind by a crashed process does not block the next start.
+func acquireLock(path string) (*lockFile, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			file.Close()
+			if err != syscall.EWOULDBLOCK {
+				return nil, err
+			}
+			if pid, err := readLockPID(path); err == nil {
+				return nil, fmt.Errorf("%s is locked by running process %d", path, pid)
+			}
+			return nil, fmt.Errorf("%s is locked by another process", path)
+		}
+
+		// The previous owner may have removed the file between our open and
+		// flock. The lock is then held on an unlinked file, so start over.
+		if !sameFile(file, path) {
+			file.Close()
+			continue
+		}
+
+		if err := writeLockPID(file); err != nil {
+			os.Remove(path)
+			file.Close()
+			return nil, err
+		}
+		return &lockFile{path: path, file: file}, nil
+	}
+
+	return nil, fmt.Errorf("Unable to acquire lock %s", path)
+}
+
+// Release removes the lock file before unlocking it so that no other process
+// can lock the file while it is being removed.
+func (l *lockFile) Release() {
+	if err := os.Remove(l.path); err != nil {
+		fmt.Printf("Could not remove lock %s: %s\n", l.path, err.Error())
+	}
+	l.file.Close()
+}
+
+func sameFile(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(opened, current)
+}
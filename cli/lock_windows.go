@@ -0,0 +1,66 @@
+//go:build windows
+// +build windows
+
+package cli
+
+import (
+	"fmt"
+	"os"
+)
+
+// acquireLock creates the file at path exclusively. Windows has no flock, so a
+// lock file left behind by a crashed process is taken over once the process
+// it names is no longer running.
+func acquireLock(path string) (*lockFile, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			if err := writeLockPID(file); err != nil {
+				file.Close()
+				os.Remove(path)
+				return nil, err
+			}
+			return &lockFile{path: path, file: file}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		// Without a PID the owner may still be writing it, so the lock is
+		// only taken over if its process is known to be gone.
+		pid, err := readLockPID(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s is locked by another process", path)
+		}
+		if isProcessRunning(pid) {
+			return nil, fmt.Errorf("%s is locked by running process %d", path, pid)
+		}
+
+		fmt.Printf("Removing stale lock %s of process %d\n", path, pid)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("Unable to acquire lock %s", path)
+}
+
+// Release closes the lock file before removing it, as Windows does not remove
+// open files.
+func (l *lockFile) Release() {
+	l.file.Close()
+	if err := os.Remove(l.path); err != nil {
+		fmt.Printf("Could not remove lock %s: %s\n", l.path, err.Error())
+	}
+}
+
+// isProcessRunning relies on FindProcess opening a handle to the process on
+// Windows, which fails once the process is gone.
+func isProcessRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
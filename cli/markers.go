@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"pget/premiumize"
+	"time"
+)
+
+// completeManifest is written to the folder of a transfer once all of its
+// files are downloaded. Transfers without a folder of their own get a manifest
+// named after their id instead, see manifestPath.
+const completeManifest = ".pget-complete.json"
+
+// inProgressMarker is created in the folder of a transfer while its files are
+// downloaded and removed afterwards, whether the download succeeded or not.
+const inProgressMarker = ".pget-inprogress"
+
+type inProgress struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+}
+
+// transferFolder returns the folder the files of a transfer are placed in.
+// That is the top level folder of the torrent if it has exactly one and the
+// structure is kept, otherwise the download directory itself.
+func transferFolder(root string, content map[string]premiumize.TorrentContent, flatten bool) string {
+	if flatten || len(content) != 1 {
+		return root
+	}
+
+	for name, value := range content {
+		if value.Type != typeFile {
+			return filepath.Join(root, name)
+		}
+	}
+	return root
+}
+
+func createInProgressMarker(folder string, transfer premiumize.TorrentItem) (string, error) {
+	if err := os.MkdirAll(folder, 0770); err != nil {
+		return "", err
+	}
+
+	content, err := json.MarshalIndent(inProgress{
+		ID:      transfer.ID,
+		Name:    transfer.Name,
+		Hash:    transfer.Hash,
+		PID:     os.Getpid(),
+		Started: time.Now(),
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	marker := filepath.Join(folder, inProgressMarker)
+	return marker, ioutil.WriteFile(marker, content, 0644)
+}
+
+func removeMarker(marker string) {
+	if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Could not remove %s: %s\n", marker, err.Error())
+	}
+}
+
+type manifest struct {
+	ID        string         `json:"id"`
+	Hash      string         `json:"hash"`
+	Name      string         `json:"name"`
+	Files     []manifestFile `json:"files"`
+	Started   time.Time      `json:"started"`
+	Completed time.Time      `json:"completed"`
+}
+
+type manifestFile struct {
+	// Path is relative to the folder of the manifest.
+	Path string `json:"path"`
+	Size uint64 `json:"size"`
+}
+
+func writeManifest(result downloadResult) error {
+	content := manifest{
+		ID:        result.Transfer.ID,
+		Hash:      result.Transfer.Hash,
+		Name:      result.Transfer.Name,
+		Files:     []manifestFile{},
+		Started:   result.Started,
+		Completed: result.Completed,
+	}
+
+	for _, task := range result.Tasks {
+		path, err := filepath.Rel(result.Folder, task.Destination)
+		if err != nil {
+			return err
+		}
+		content.Files = append(content.Files, manifestFile{
+			Path: filepath.ToSlash(path),
+			Size: task.Size,
+		})
+	}
+
+	data, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(result.Folder, 0770); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so the manifest only ever appears complete
+	path := manifestPath(result)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// manifestPath returns where the manifest of a transfer is written. Transfers
+// sharing a folder, e.g. when flattened, get one manifest per transfer id.
+func manifestPath(result downloadResult) string {
+	if result.SharedFolder {
+		return filepath.Join(result.Folder, ".pget-complete-"+result.Transfer.ID+".json")
+	}
+	return filepath.Join(result.Folder, completeManifest)
+}
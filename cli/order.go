@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"fmt"
+	"pget/premiumize"
+	"sort"
+	"time"
+)
+
+const priorityBucket = "priority"
+
+// Transfer orders
+const orderListed = "listed"
+const orderSmallest = "smallest"
+const orderOldest = "oldest"
+const orderPriority = "priority"
+
+// Task orders
+const orderName = "name"
+
+// OrderConfig selects in which order watch downloads finished transfers
+// (listed, smallest, oldest or priority) and in which order the files of a
+// download are fetched (name or smallest). Transfers given to pget prioritize
+// always come first.
+type OrderConfig struct {
+	Transfers string `mapstructure:"transfers"`
+	Tasks     string `mapstructure:"tasks"`
+}
+
+func (c *Cli) SetOrder(config OrderConfig) error {
+	switch config.Transfers {
+	case "", orderListed, orderSmallest, orderOldest, orderPriority:
+	default:
+		return fmt.Errorf("Unknown transfer order %s", config.Transfers)
+	}
+	switch config.Tasks {
+	case "", orderName, orderSmallest:
+	default:
+		return fmt.Errorf("Unknown task order %s", config.Tasks)
+	}
+
+	c.orderConfig = config
+	return nil
+}
+
+// Prioritize moves a transfer ahead of all others that are waiting for
+// download by watch. Transfers prioritized earlier stay in front of it.
+func (c *Cli) Prioritize(name string) {
+	transfer, err := c.premiumize.FindTorrentByName(name)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	if c.isDownloaded(transfer.ID) {
+		fmt.Printf("%s has already been downloaded\n", transfer.Name)
+		return
+	}
+
+	if found, err := c.getJSON(priorityBucket, transfer.ID, &time.Time{}); err == nil && found {
+		fmt.Printf("%s is already prioritized\n", transfer.Name)
+		return
+	}
+
+	if err := c.putJSON(priorityBucket, transfer.ID, time.Now()); err != nil {
+		fmt.Printf("Could not prioritize %s: %s\n", transfer.Name, err.Error())
+		return
+	}
+	fmt.Printf("Prioritized %s\n", transfer.Name)
+}
+
+func (c *Cli) prioritized() map[string]time.Time {
+	prioritized := make(map[string]time.Time)
+	err := c.forEachJSON(priorityBucket, func() interface{} { return &time.Time{} }, func(key string, value interface{}) {
+		prioritized[key] = *value.(*time.Time)
+	})
+	if err != nil {
+		fmt.Printf("Could not read prioritized transfers: %s\n", err.Error())
+	}
+	return prioritized
+}
+
+func (c *Cli) unprioritize(id string) {
+	if err := c.deleteKey(priorityBucket, id); err != nil {
+		fmt.Printf("Could not remove priority of %s: %s\n", id, err.Error())
+	}
+}
+
+// orderTransfers returns the transfers in the order they should be downloaded.
+// listed holds all transfers as listed by premiumize, which is the order of
+// the listed policy and breaks ties of the others. seen is used by the oldest
+// policy, see firstSeen.
+func (c *Cli) orderTransfers(transfers []premiumize.TorrentItem, listed []premiumize.TorrentItem, seen map[string]time.Time, defaults Category) []premiumize.TorrentItem {
+	index := make(map[string]int)
+	for i, transfer := range listed {
+		index[transfer.ID] = i
+	}
+
+	priority := make(map[string]int)
+	if c.orderConfig.Transfers == orderPriority {
+		for _, transfer := range transfers {
+			priority[transfer.ID] = c.categoryFor(c.uploadLocation(transfer), defaults).Priority
+		}
+	}
+
+	prioritized := c.prioritized()
+
+	ordered := append([]premiumize.TorrentItem(nil), transfers...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+
+		aPrioritized, aOk := prioritized[a.ID]
+		bPrioritized, bOk := prioritized[b.ID]
+		if aOk != bOk {
+			return aOk
+		}
+		if aOk && !aPrioritized.Equal(bPrioritized) {
+			return aPrioritized.Before(bPrioritized)
+		}
+
+		switch c.orderConfig.Transfers {
+		case orderSmallest:
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case orderOldest:
+			if !seen[a.ID].Equal(seen[b.ID]) {
+				return seen[a.ID].Before(seen[b.ID])
+			}
+		case orderPriority:
+			if priority[a.ID] != priority[b.ID] {
+				return priority[a.ID] > priority[b.ID]
+			}
+		}
+		return index[a.ID] < index[b.ID]
+	})
+	return ordered
+}
+
+// orderTasks sorts the files of a download by name or size.
+func (c *Cli) orderTasks(tasks []DownloadTask) {
+	sort.Sort(DownloadTaskSorter(tasks))
+	if c.orderConfig.Tasks == orderSmallest {
+		sort.SliceStable(tasks, func(i, j int) bool {
+			return tasks[i].Size < tasks[j].Size
+		})
+	}
+}
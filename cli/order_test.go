@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"pget/premiumize"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func transferIDs(transfers []premiumize.TorrentItem) []string {
+	var ids []string
+	for _, transfer := range transfers {
+		ids = append(ids, transfer.ID)
+	}
+	return ids
+}
+
+func TestOrderTransfers(t *testing.T) {
+	defer inTempDir(t)()
+
+	now := time.Now()
+	transfers := []premiumize.TorrentItem{
+		{ID: "a", Name: "a", Size: 300},
+		{ID: "b", Name: "b", Size: 100},
+		{ID: "c", Name: "c", Size: 200},
+		{ID: "d", Name: "d", Size: 100},
+	}
+	seen := map[string]time.Time{"a": now.Add(-3 * time.Hour), "b": now.Add(-time.Hour), "c": now.Add(-2 * time.Hour), "d": now.Add(-time.Hour)}
+	locations := map[string]string{"b": "low", "c": "high/movies"}
+
+	c := New(nil)
+	c.SetCategories(map[string]Category{"high": {Priority: 2}, "low": {Priority: -1}})
+	for id, location := range locations {
+		if err := c.storeUploadLocation(id, location); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		order       string
+		prioritized []string
+		want        []string
+	}{
+		{order: "", want: []string{"a", "b", "c", "d"}},
+		{order: orderListed, want: []string{"a", "b", "c", "d"}},
+		{order: orderSmallest, want: []string{"b", "d", "c", "a"}},
+		{order: orderOldest, want: []string{"a", "c", "b", "d"}},
+		{order: orderPriority, want: []string{"c", "a", "d", "b"}},
+		{order: orderSmallest, prioritized: []string{"a"}, want: []string{"a", "b", "d", "c"}},
+		{order: orderListed, prioritized: []string{"d", "c"}, want: []string{"d", "c", "a", "b"}},
+	}
+
+	for _, test := range tests {
+		if err := c.SetOrder(OrderConfig{Transfers: test.order}); err != nil {
+			t.Fatal(err)
+		}
+		for i, id := range test.prioritized {
+			if err := c.putJSON(priorityBucket, id, now.Add(time.Duration(i)*time.Second)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got := transferIDs(c.orderTransfers(transfers, transfers, seen, Category{}))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q with %v prioritized: order %v, want %v", test.order, test.prioritized, got, test.want)
+		}
+
+		for _, id := range test.prioritized {
+			c.unprioritize(id)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"path/filepath"
+	"pget/premiumize"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const queueBucket = "queue"
+
+// queueRetryDelay is how long a queued upload which failed is skipped.
+const queueRetryDelay = 10 * time.Minute
+
+var errAlreadyQueued = fmt.Errorf("Torrent is queued already")
+
+// queuedUpload is a torrent or magnet file waiting to be uploaded. The file is
+// kept in the torrent archive until then.
+type queuedUpload struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	File     string    `json:"file"`
+	Location string    `json:"location"`
+	Added    time.Time `json:"added"`
+	Position int       `json:"position"`
+	Error    string    `json:"error,omitempty"`
+	Failed   time.Time `json:"failed,omitempty"`
+}
+
+// enqueue adds an archived torrent or magnet file to the end of the upload
+// queue. errAlreadyQueued is returned if the torrent is queued already.
+func (c *Cli) enqueue(file string, name string, location string) error {
+	entries, err := c.queuedUploads()
+	if err != nil {
+		return err
+	}
+
+	// Archived files are named by their info hash
+	for _, entry := range entries {
+		if uploadName(entry.File) == uploadName(file) {
+			return errAlreadyQueued
+		}
+	}
+
+	position := 1
+	if len(entries) > 0 {
+		position = entries[len(entries)-1].Position + 1
+	}
+
+	return c.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(queueBucket))
+		if err != nil {
+			return err
+		}
+		sequence, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		entry := queuedUpload{
+			ID:       strconv.FormatUint(sequence, 10),
+			Name:     name,
+			File:     file,
+			Location: location,
+			Added:    time.Now(),
+			Position: position,
+		}
+		return putJSONTx(bucket, entry.ID, entry)
+	})
+}
+
+// queuedUploads returns the upload queue in order.
+func (c *Cli) queuedUploads() ([]queuedUpload, error) {
+	var entries []queuedUpload
+	err := c.forEachJSON(queueBucket, func() interface{} { return &queuedUpload{} }, func(key string, value interface{}) {
+		entries = append(entries, *value.(*queuedUpload))
+	})
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Position < entries[j].Position
+	})
+	return entries, err
+}
+
+// uploadQueue processes the upload queue whenever a file has been queued and
+// every minute, to pick up free slots and retry failed uploads.
+func (c *Cli) uploadQueue(ctx context.Context, queued <-chan struct{}, maxActive int) {
+	for {
+		c.processQueue(ctx, maxActive)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-queued:
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+// processQueue uploads queued files in order, as long as fewer than maxActive
+// transfers are running on premiumize. Zero means no limit.
+func (c *Cli) processQueue(ctx context.Context, maxActive int) {
+	entries, err := c.queuedUploads()
+	if err != nil {
+		fmt.Printf("Could not read upload queue: %s\n", err.Error())
+		return
+	}
+	if len(entries) == 0 || c.areUploadsPaused() {
+		return
+	}
+
+	active := 0
+	if maxActive > 0 {
+		torrents, err := c.premiumize.ListTorrents()
+		if err != nil {
+			fmt.Printf("Could not retrieve list of torrents: %s\n", err.Error())
+			return
+		}
+		active = c.activeTransfers(torrents.Transfers)
+	}
+
+	uploadDue(ctx, entries, active, maxActive, time.Now(), c.uploadQueued)
+}
+
+// activeTransfers counts the transfers which are neither finished nor failed.
+func (c *Cli) activeTransfers(transfers []premiumize.TorrentItem) int {
+	active := 0
+	for _, transfer := range transfers {
+		if !c.isTorrentFinished(transfer.Status) && !containsFold(failedStatuses, transfer.Status) {
+			active++
+		}
+	}
+	return active
+}
+
+// uploadDue uploads the entries in order while fewer than maxActive transfers
+// are active, skipping entries which failed within queueRetryDelay. Only
+// successful uploads take a slot.
+func uploadDue(ctx context.Context, entries []queuedUpload, active int, maxActive int, now time.Time, upload func(queuedUpload) bool) {
+	for _, entry := range entries {
+		if ctx.Err() != nil || (maxActive > 0 && active >= maxActive) {
+			return
+		}
+		if !entry.Failed.IsZero() && now.Sub(entry.Failed) < queueRetryDelay {
+			continue
+		}
+		if upload(entry) {
+			active++
+		}
+	}
+}
+
+func (c *Cli) uploadQueued(entry queuedUpload) bool {
+	hooks := c.hooksFor(c.categoryFor(entry.Location, Category{}))
+
+	resp, err := c.uploadToCloudFolder(entry.File, entry.Location)
+	if err == nil {
+		c.runHook(hooks, hookUpload, hookEnv{
+			ID:    resp.ID,
+			Name:  resp.Name,
+			Files: []string{entry.File},
+		})
+		if err := c.storeUploadLocation(resp.ID, entry.Location); err != nil {
+			fmt.Printf("Failed to store torrent %s in database, this torrent will not be automatically downloaded: %s\n", entry.Name, err.Error())
+		}
+		if err := c.deleteKey(queueBucket, entry.ID); err != nil {
+			fmt.Printf("Could not remove %s from the upload queue: %s\n", entry.Name, err.Error())
+		}
+		fmt.Printf("Uploaded %s\n", entry.Name)
+		return true
+	}
+
+	fmt.Printf("Failed to upload %s: %s\n", entry.Name, err.Error())
+	c.runHook(hooks, hookError, hookEnv{
+		Name:  entry.Name,
+		Files: []string{entry.File},
+		Error: err.Error(),
+	})
+
+	entry.Error = err.Error()
+	entry.Failed = time.Now()
+	if err := c.putJSON(queueBucket, entry.ID, entry); err != nil {
+		fmt.Printf("Could not update %s in the upload queue: %s\n", entry.Name, err.Error())
+	}
+	return false
+}
+
+func (c *Cli) QueueList() {
+	entries, err := c.queuedUploads()
+	if err != nil {
+		fmt.Printf("Could not read upload queue: %s\n", err.Error())
+		return
+	}
+
+	for i, entry := range entries {
+		location := entry.Location
+		if location == "" {
+			location = "/"
+		}
+		fmt.Printf("%d. %s [%s] [%s]", i+1, entry.Name, location, entry.Added.Format(time.RFC822))
+		if entry.Error != "" {
+			fmt.Printf(" [failed: %s]", entry.Error)
+		}
+		fmt.Println()
+	}
+}
+
+func (c *Cli) QueueRemove(name string) {
+	entry, err := c.findQueued(name)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	if err := c.deleteKey(queueBucket, entry.ID); err != nil {
+		fmt.Printf("Unable to remove %s: %s\n", entry.Name, err.Error())
+		return
+	}
+	fmt.Printf("Removed %s from the upload queue\n", entry.Name)
+}
+
+// QueueMove moves a queued upload to the given position, starting at 1.
+func (c *Cli) QueueMove(name string, position int) {
+	entry, err := c.findQueued(name)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+
+	entries, err := c.queuedUploads()
+	if err != nil {
+		fmt.Printf("Could not read upload queue: %s\n", err.Error())
+		return
+	}
+
+	var reordered []queuedUpload
+	for _, current := range entries {
+		if current.ID != entry.ID {
+			reordered = append(reordered, current)
+		}
+	}
+
+	index := position - 1
+	if index < 0 {
+		index = 0
+	}
+	if index > len(reordered) {
+		index = len(reordered)
+	}
+	reordered = append(reordered[:index], append([]queuedUpload{entry}, reordered[index:]...)...)
+
+	err = c.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(queueBucket))
+		if err != nil {
+			return err
+		}
+		for i, current := range reordered {
+			current.Position = i + 1
+			if err := putJSONTx(bucket, current.ID, current); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Unable to move %s: %s\n", entry.Name, err.Error())
+		return
+	}
+	fmt.Printf("Moved %s to position %d\n", entry.Name, index+1)
+}
+
+// findQueued returns the queued upload with the given name or id.
+func (c *Cli) findQueued(name string) (queuedUpload, error) {
+	entries, err := c.queuedUploads()
+	if err != nil {
+		return queuedUpload{}, err
+	}
+
+	for _, entry := range entries {
+		if entry.ID == name || strings.EqualFold(entry.Name, name) {
+			return entry, nil
+		}
+	}
+	return queuedUpload{}, fmt.Errorf("'%s' is not queued for upload", name)
+}
+
+// uploadName returns the name a torrent or magnet file is queued under.
+func uploadName(filePath string) string {
+	name := filepath.Base(filePath)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"context"
+	"pget/premiumize"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUploadDue(t *testing.T) {
+	now := time.Now()
+	entries := []queuedUpload{
+		{ID: "1"},
+		{ID: "2", Failed: now.Add(-time.Minute)},
+		{ID: "3"},
+		{ID: "4", Failed: now.Add(-queueRetryDelay - time.Minute)},
+		{ID: "5"},
+	}
+
+	tests := []struct {
+		description string
+		active      int
+		maxActive   int
+		failing     map[string]bool
+		want        []string
+	}{
+		{description: "no limit", want: []string{"1", "3", "4", "5"}},
+		{description: "free slots", active: 1, maxActive: 3, want: []string{"1", "3"}},
+		{description: "no free slot", active: 3, maxActive: 3},
+		{description: "more active than allowed", active: 4, maxActive: 3},
+		{description: "failed uploads keep their slot free", active: 1, maxActive: 3, failing: map[string]bool{"1": true}, want: []string{"1", "3", "4"}},
+	}
+
+	for _, test := range tests {
+		var attempted []string
+		uploadDue(context.Background(), entries, test.active, test.maxActive, now, func(entry queuedUpload) bool {
+			attempted = append(attempted, entry.ID)
+			return !test.failing[entry.ID]
+		})
+		if !reflect.DeepEqual(attempted, test.want) {
+			t.Errorf("%s: uploaded %v, want %v", test.description, attempted, test.want)
+		}
+	}
+}
+
+func TestUploadDueStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var attempted []string
+	uploadDue(ctx, []queuedUpload{{ID: "1"}, {ID: "2"}}, 0, 0, time.Now(), func(entry queuedUpload) bool {
+		attempted = append(attempted, entry.ID)
+		cancel()
+		return true
+	})
+	if want := []string{"1"}; !reflect.DeepEqual(attempted, want) {
+		t.Errorf("uploaded %v, want %v", attempted, want)
+	}
+}
+
+func TestActiveTransfers(t *testing.T) {
+	transfers := []premiumize.TorrentItem{
+		{Status: "running"},
+		{Status: "queued"},
+		{Status: "finished"},
+		{Status: "error"},
+		{Status: "timeout"},
+		{Status: "seeding"},
+	}
+	if active := New(nil).activeTransfers(transfers); active != 3 {
+		t.Errorf("activeTransfers() = %d, want 3", active)
+	}
+}
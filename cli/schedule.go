@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errDownloadWindowClosed = fmt.Errorf("Download window closed")
+
+const scheduleFinish = "finish"
+const schedulePause = "pause"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ScheduleConfig holds the windows in which watch starts downloads, like
+// "mon-fri 01:00-07:00" or "sat,sun 22:00-06:00". Without windows downloads
+// start any time.
+type ScheduleConfig struct {
+	Windows []string `mapstructure:"windows"`
+	// OnClose is finish (default), which completes the running download, or
+	// pause, which stops it once a window closes.
+	OnClose string `mapstructure:"on_close"`
+}
+
+type scheduleWindow struct {
+	days  [7]bool
+	start time.Duration
+	end   time.Duration
+}
+
+// SetSchedule parses the download windows.
+func (c *Cli) SetSchedule(config ScheduleConfig) error {
+	if config.OnClose != "" && config.OnClose != scheduleFinish && config.OnClose != schedulePause {
+		return fmt.Errorf("Unknown on_close %s, use %s or %s", config.OnClose, scheduleFinish, schedulePause)
+	}
+
+	var windows []scheduleWindow
+	for _, definition := range config.Windows {
+		window, err := parseScheduleWindow(definition)
+		if err != nil {
+			return err
+		}
+		windows = append(windows, window)
+	}
+
+	c.scheduleWindows = windows
+	c.schedulePause = config.OnClose == schedulePause
+	return nil
+}
+
+func parseScheduleWindow(definition string) (scheduleWindow, error) {
+	fields := strings.Fields(strings.ToLower(definition))
+	if len(fields) != 2 {
+		return scheduleWindow{}, fmt.Errorf("Invalid schedule window '%s', expected days and times like 'mon-fri 01:00-07:00'", definition)
+	}
+
+	var window scheduleWindow
+	for _, days := range strings.Split(fields[0], ",") {
+		if days == "*" || days == "daily" {
+			window.days = [7]bool{true, true, true, true, true, true, true}
+			continue
+		}
+
+		bounds := strings.SplitN(days, "-", 2)
+		first, ok := weekdays[bounds[0]]
+		last := first
+		if ok && len(bounds) == 2 {
+			last, ok = weekdays[bounds[1]]
+		}
+		if !ok {
+			return scheduleWindow{}, fmt.Errorf("Invalid days '%s' in schedule window '%s'", days, definition)
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			window.days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	times := strings.SplitN(fields[1], "-", 2)
+	if len(times) != 2 {
+		return scheduleWindow{}, fmt.Errorf("Invalid times '%s' in schedule window '%s'", fields[1], definition)
+	}
+	var err error
+	if window.start, err = parseTimeOfDay(times[0]); err != nil {
+		return scheduleWindow{}, err
+	}
+	if window.end, err = parseTimeOfDay(times[1]); err != nil {
+		return scheduleWindow{}, err
+	}
+	return window, nil
+}
+
+// parseTimeOfDay parses HH:MM into the time since midnight, up to 24:00.
+func parseTimeOfDay(value string) (time.Duration, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("Invalid time '%s', expected HH:MM", value)
+	}
+
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time '%s', expected HH:MM", value)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || hours < 0 || minutes < 0 || minutes > 59 || hours*60+minutes > 24*60 {
+		return 0, fmt.Errorf("Invalid time '%s', expected HH:MM", value)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// contains returns whether the window is open at the given time. Windows
+// ending before they start run past midnight into the next day.
+func (window scheduleWindow) contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+
+	if window.start < window.end {
+		return window.days[today] && offset >= window.start && offset < window.end
+	}
+	return (window.days[today] && offset >= window.start) || (window.days[yesterday] && offset < window.end)
+}
+
+// isDownloadWindowOpen returns whether downloads may start now.
+func (c *Cli) isDownloadWindowOpen() bool {
+	if len(c.scheduleWindows) == 0 {
+		return true
+	}
+
+	now := time.Now()
+	for _, window := range c.scheduleWindows {
+		if window.contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduledContext returns a context which, when pausing on close, is done
+// once the download window closes.
+func (c *Cli) scheduledContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if !c.schedulePause || len(c.scheduleWindows) == 0 {
+		return ctx, cancel
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Minute):
+			}
+
+			if !c.isDownloadWindowOpen() {
+				fmt.Println("Download window closed, pausing downloads")
+				cancel()
+				return
+			}
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleWindow(t *testing.T) {
+	tests := []struct {
+		definition string
+		days       [7]bool
+		start      time.Duration
+		end        time.Duration
+		wantErr    bool
+	}{
+		{definition: "mon-fri 01:00-07:00", days: [7]bool{false, true, true, true, true, true, false}, start: time.Hour, end: 7 * time.Hour},
+		{definition: "sat,sun 22:00-06:00", days: [7]bool{true, false, false, false, false, false, true}, start: 22 * time.Hour, end: 6 * time.Hour},
+		{definition: "fri-mon 23:30-24:00", days: [7]bool{true, true, false, false, false, true, true}, start: 23*time.Hour + 30*time.Minute, end: 24 * time.Hour},
+		{definition: "daily 00:00-08:15", days: [7]bool{true, true, true, true, true, true, true}, end: 8*time.Hour + 15*time.Minute},
+		{definition: "*  9:05-17:00", days: [7]bool{true, true, true, true, true, true, true}, start: 9*time.Hour + 5*time.Minute, end: 17 * time.Hour},
+		{definition: "Wed 01:00-02:00", days: [7]bool{false, false, false, true, false, false, false}, start: time.Hour, end: 2 * time.Hour},
+		{definition: "mon-fri", wantErr: true},
+		{definition: "mon-fri 01:00-07:00 extra", wantErr: true},
+		{definition: "monday 01:00-07:00", wantErr: true},
+		{definition: "mon-xyz 01:00-07:00", wantErr: true},
+		{definition: "mon 01:00", wantErr: true},
+		{definition: "mon 01:00-24:01", wantErr: true},
+		{definition: "mon 01:60-02:00", wantErr: true},
+		{definition: "mon 1-2", wantErr: true},
+		{definition: "mon -01:00-02:00", wantErr: true},
+	}
+
+	for _, test := range tests {
+		window, err := parseScheduleWindow(test.definition)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseScheduleWindow(%q) = %+v, want an error", test.definition, window)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseScheduleWindow(%q) failed: %s", test.definition, err)
+			continue
+		}
+		if window.days != test.days || window.start != test.start || window.end != test.end {
+			t.Errorf("parseScheduleWindow(%q) = %+v, want days %v from %s to %s", test.definition, window, test.days, test.start, test.end)
+		}
+	}
+}
+
+func TestScheduleWindowContains(t *testing.T) {
+	// 2024-01-01 is a Monday.
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2024, time.January, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		definition string
+		time       time.Time
+		want       bool
+	}{
+		{"mon-fri 01:00-07:00", at(1, 1, 0), true},
+		{"mon-fri 01:00-07:00", at(1, 6, 59), true},
+		{"mon-fri 01:00-07:00", at(1, 7, 0), false},
+		{"mon-fri 01:00-07:00", at(1, 0, 59), false},
+		{"mon-fri 01:00-07:00", at(6, 3, 0), false},
+
+		// Windows ending before they start run past midnight.
+		{"fri 22:00-06:00", at(5, 22, 0), true},
+		{"fri 22:00-06:00", at(5, 21, 59), false},
+		{"fri 22:00-06:00", at(6, 0, 0), true},
+		{"fri 22:00-06:00", at(6, 5, 59), true},
+		{"fri 22:00-06:00", at(6, 6, 0), false},
+		{"fri 22:00-06:00", at(5, 3, 0), false},
+		{"fri 22:00-06:00", at(6, 22, 30), false},
+		{"sun 23:00-01:00", at(7, 23, 30), true},
+		{"sun 23:00-01:00", at(8, 0, 30), true},
+		{"sun 23:00-01:00", at(7, 0, 30), false},
+
+		{"daily 00:00-24:00", at(3, 0, 0), true},
+		{"daily 00:00-24:00", at(3, 23, 59), true},
+		{"sat 20:00-24:00", at(6, 23, 59), true},
+		{"sat 20:00-24:00", at(7, 0, 0), false},
+	}
+
+	for _, test := range tests {
+		window, err := parseScheduleWindow(test.definition)
+		if err != nil {
+			t.Fatalf("parseScheduleWindow(%q) failed: %s", test.definition, err)
+		}
+		if got := window.contains(test.time); got != test.want {
+			t.Errorf("%q contains %s = %v, want %v", test.definition, test.time.Format("Mon 15:04"), got, test.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"pget/premiumize"
+	"strings"
+	"time"
+)
+
+const progressBucket = "progress"
+
+const stalledNotify = "notify"
+const stalledDelete = "delete"
+const stalledReupload = "reupload"
+
+var failedStatuses = []string{"error", "timeout"}
+
+// StalledConfig holds how transfers without progress or in an error state are
+// handled. Every action runs the on_stalled hook.
+type StalledConfig struct {
+	// After is how long a transfer may go without progress.
+	After time.Duration `mapstructure:"after"`
+	// Action is notify (default), delete or reupload, which deletes the
+	// transfer and uploads the archived torrent or magnet file again.
+	Action string `mapstructure:"action"`
+	// Retries limits how often a torrent is uploaded again, defaults to 1.
+	Retries int `mapstructure:"retries"`
+}
+
+func (config StalledConfig) enabled() bool {
+	return config.After > 0 || config.Action != ""
+}
+
+func (c *Cli) SetStalled(config StalledConfig) error {
+	switch config.Action {
+	case "", stalledNotify, stalledDelete, stalledReupload:
+	default:
+		return fmt.Errorf("Unknown stalled action %s", config.Action)
+	}
+
+	c.stalledConfig = config
+	return nil
+}
+
+// transferProgress tracks an unfinished transfer, keyed by its hash so it
+// survives uploading the torrent again.
+type transferProgress struct {
+	ID        string    `json:"id"`
+	Progress  float64   `json:"progress"`
+	Seeder    int       `json:"seeder"`
+	Status    string    `json:"status"`
+	Changed   time.Time `json:"changed"`
+	Flagged   bool      `json:"flagged"`
+	Reuploads int       `json:"reuploads"`
+}
+
+// checkStalled flags transfers which made no progress for too long or failed,
+// once per transfer.
+func (c *Cli) checkStalled(transfers []premiumize.TorrentItem) {
+	records := make(map[string]*transferProgress)
+	err := c.forEachJSON(progressBucket, func() interface{} { return &transferProgress{} }, func(key string, value interface{}) {
+		records[key] = value.(*transferProgress)
+	})
+	if err != nil {
+		fmt.Printf("Could not read transfer progress: %s\n", err.Error())
+		return
+	}
+
+	active := make(map[string]bool)
+	for _, transfer := range transfers {
+		if c.isTorrentFinished(transfer.Status) {
+			continue
+		}
+
+		key := strings.ToLower(transfer.Hash)
+		active[key] = true
+
+		record, ok := records[key]
+		if !ok {
+			record = &transferProgress{}
+		}
+
+		reason := trackProgress(record, transfer, c.stalledConfig.After, time.Now())
+		if reason != "" && !record.Flagged {
+			record.Flagged = true
+			c.handleStalled(transfer, reason, record)
+		}
+		records[key] = record
+	}
+
+	err = c.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(progressBucket))
+		if err != nil {
+			return err
+		}
+		for key, record := range records {
+			if !active[key] {
+				err = bucket.Delete([]byte(key))
+			} else {
+				err = putJSONTx(bucket, key, record)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Could not record transfer progress: %s\n", err.Error())
+	}
+}
+
+// trackProgress updates the record of a transfer and returns why the transfer
+// is stalled, or an empty string. Uploading the transfer again gives it a new
+// ID, which resets the record.
+func trackProgress(record *transferProgress, transfer premiumize.TorrentItem, after time.Duration, now time.Time) string {
+	if record.ID != transfer.ID {
+		record.Flagged = false
+		record.Changed = now
+	}
+	if record.Progress != transfer.Progress || record.Status != transfer.Status {
+		record.Changed = now
+	}
+	record.ID = transfer.ID
+	record.Progress = transfer.Progress
+	record.Seeder = transfer.Seeder
+	record.Status = transfer.Status
+
+	if containsFold(failedStatuses, transfer.Status) {
+		return "status " + transfer.Status
+	}
+	if after > 0 && now.Sub(record.Changed) > after {
+		return fmt.Sprintf("no progress for %s, %d seeders", after, transfer.Seeder)
+	}
+	return ""
+}
+
+func (c *Cli) handleStalled(transfer premiumize.TorrentItem, reason string, record *transferProgress) {
+	fmt.Printf("%s is stalled: %s\n", transfer.Name, reason)
+
+	location := c.uploadLocation(transfer)
+	c.runHook(c.hooksFor(c.categoryFor(location, Category{})), hookStalled, hookEnv{
+		ID:    transfer.ID,
+		Name:  transfer.Name,
+		Hash:  transfer.Hash,
+		Error: reason,
+	})
+
+	switch c.stalledConfig.Action {
+	case "", stalledNotify:
+	case stalledDelete:
+		if _, err := c.premiumize.DeleteTorrent(transfer.ID); err != nil {
+			fmt.Printf("Unable to delete %s: %s\n", transfer.Name, err.Error())
+			return
+		}
+		fmt.Printf("Deleted stalled %s\n", transfer.Name)
+	case stalledReupload:
+		retries := c.stalledConfig.Retries
+		if retries == 0 {
+			retries = 1
+		}
+		if record.Reuploads >= retries {
+			fmt.Printf("Not uploading %s again, it has been uploaded again %d times already\n", transfer.Name, record.Reuploads)
+			return
+		}
+		if err := c.reupload(transfer, location); err != nil {
+			fmt.Printf("Unable to upload %s again: %s\n", transfer.Name, err.Error())
+			return
+		}
+		record.Reuploads++
+	}
+}
+
+// reupload replaces a transfer by uploading its archived torrent or magnet
+// file to the same cloud folder again, once premiumize deleted the transfer.
+func (c *Cli) reupload(transfer premiumize.TorrentItem, location string) error {
+	archived, err := archivedUpload(transfer.Hash)
+	if err != nil {
+		return err
+	}
+
+	// The folder is looked up before deleting, so a missing folder keeps the
+	// transfer
+	if _, err := c.ensureCloudFolder(location); err != nil {
+		return err
+	}
+
+	// Uploading the same torrent again while it is still listed fails or
+	// doubles it, so nothing is uploaded if it cannot be deleted.
+	if _, err := c.premiumize.DeleteTorrent(transfer.ID); err != nil {
+		return fmt.Errorf("Unable to delete the stalled transfer: %s", err.Error())
+	}
+
+	resp, err := c.uploadToCloudFolder(archived, location)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Uploaded %s again\n", transfer.Name)
+
+	return c.storeUploadLocation(resp.ID, location)
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"pget/premiumize"
+	"testing"
+	"time"
+)
+
+func TestTrackProgress(t *testing.T) {
+	start := time.Now()
+	after := time.Hour
+
+	steps := []struct {
+		description string
+		transfer    premiumize.TorrentItem
+		at          time.Duration
+		want        string
+	}{
+		{description: "new transfer", transfer: premiumize.TorrentItem{ID: "1", Status: "running", Progress: 0.1, Seeder: 3}},
+		{description: "no progress yet", transfer: premiumize.TorrentItem{ID: "1", Status: "running", Progress: 0.1, Seeder: 3}, at: 30 * time.Minute},
+		{description: "progress", transfer: premiumize.TorrentItem{ID: "1", Status: "running", Progress: 0.2, Seeder: 3}, at: 50 * time.Minute},
+		{description: "seeders do not reset the timer", transfer: premiumize.TorrentItem{ID: "1", Status: "running", Progress: 0.2, Seeder: 2}, at: 100 * time.Minute},
+		{description: "stalled", transfer: premiumize.TorrentItem{ID: "1", Status: "running", Progress: 0.2, Seeder: 2}, at: 111 * time.Minute, want: "no progress for 1h0m0s, 2 seeders"},
+		{description: "status change resets the timer", transfer: premiumize.TorrentItem{ID: "1", Status: "queued", Progress: 0.2}, at: 120 * time.Minute},
+		{description: "failed", transfer: premiumize.TorrentItem{ID: "1", Status: "error", Progress: 0.2}, at: 121 * time.Minute, want: "status error"},
+		{description: "uploaded again", transfer: premiumize.TorrentItem{ID: "2", Status: "queued"}, at: 122 * time.Minute},
+		{description: "uploaded again and stalled", transfer: premiumize.TorrentItem{ID: "2", Status: "queued"}, at: 183 * time.Minute, want: "no progress for 1h0m0s, 0 seeders"},
+	}
+
+	record := &transferProgress{}
+	for _, step := range steps {
+		if got := trackProgress(record, step.transfer, after, start.Add(step.at)); got != step.want {
+			t.Errorf("%s: reason %q, want %q", step.description, got, step.want)
+		}
+		if record.ID != step.transfer.ID || record.Progress != step.transfer.Progress || record.Status != step.transfer.Status || record.Seeder != step.transfer.Seeder {
+			t.Errorf("%s: record %+v does not match the transfer", step.description, record)
+		}
+		if step.description == "uploaded again" && record.Flagged {
+			t.Errorf("%s: record is still flagged", step.description)
+		}
+		if step.want != "" {
+			record.Flagged = true
+		}
+	}
+}
+
+func TestTrackProgressWithoutAfter(t *testing.T) {
+	record := &transferProgress{}
+	transfer := premiumize.TorrentItem{ID: "1", Status: "running"}
+	trackProgress(record, transfer, 0, time.Now())
+	if reason := trackProgress(record, transfer, 0, time.Now().Add(24*time.Hour)); reason != "" {
+		t.Errorf("reason %q, want none without after", reason)
+	}
+	if reason := trackProgress(record, premiumize.TorrentItem{ID: "1", Status: "timeout"}, 0, time.Now()); reason != "status timeout" {
+		t.Errorf("reason %q, want status timeout", reason)
+	}
+}
@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const magnetPrefix = "magnet:"
+const btihPrefix = "urn:btih:"
+
+// infoHash returns the hex encoded info hash of a torrent file or of a file
+// containing a magnet link, as placed in the upload directory.
+func infoHash(filePath string) (string, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasSuffix(filePath, ".torrent") {
+		return infoHashFromTorrent(content)
+	}
+	return infoHashFromMagnet(strings.TrimSpace(string(content)))
+}
+
+// infoHashFromTorrent hashes the info dictionary exactly as it is stored in
+// the torrent file. Decoding and encoding it again would sort its keys and
+// change the hash of torrents which do not.
+func infoHashFromTorrent(content []byte) (string, error) {
+	if len(content) == 0 || content[0] != 'd' {
+		return "", fmt.Errorf("Torrent file is not a dictionary")
+	}
+
+	var info []byte
+	offset := 1
+	for offset < len(content) && content[offset] != 'e' {
+		key, valueStart, err := bencodeString(content, offset)
+		if err != nil {
+			return "", err
+		}
+		valueEnd, err := bencodeEnd(content, valueStart)
+		if err != nil {
+			return "", err
+		}
+		if key == "info" && content[valueStart] == 'd' {
+			info = content[valueStart:valueEnd]
+		}
+		offset = valueEnd
+	}
+	if offset >= len(content) {
+		return "", errInvalidBencode
+	}
+	if info == nil {
+		return "", fmt.Errorf("Torrent file has no info dictionary")
+	}
+
+	sum := sha1.Sum(info)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+var errInvalidBencode = fmt.Errorf("Torrent file is not valid bencode")
+
+// bencodeString decodes the bencoded string at start and returns it together
+// with the offset following it.
+func bencodeString(content []byte, start int) (string, int, error) {
+	colon := bytes.IndexByte(content[start:], ':')
+	if colon <= 0 {
+		return "", 0, errInvalidBencode
+	}
+	length, err := strconv.Atoi(string(content[start : start+colon]))
+	if err != nil || length < 0 || length > len(content)-start-colon-1 {
+		return "", 0, errInvalidBencode
+	}
+	end := start + colon + 1 + length
+	return string(content[start+colon+1 : end]), end, nil
+}
+
+// bencodeEnd returns the offset following the bencoded value at start.
+func bencodeEnd(content []byte, start int) (int, error) {
+	if start >= len(content) {
+		return 0, errInvalidBencode
+	}
+
+	switch content[start] {
+	case 'i':
+		end := bytes.IndexByte(content[start:], 'e')
+		if end < 0 {
+			return 0, errInvalidBencode
+		}
+		return start + end + 1, nil
+	case 'l', 'd':
+		offset := start + 1
+		for offset < len(content) && content[offset] != 'e' {
+			end, err := bencodeEnd(content, offset)
+			if err != nil {
+				return 0, err
+			}
+			offset = end
+		}
+		if offset >= len(content) {
+			return 0, errInvalidBencode
+		}
+		return offset + 1, nil
+	}
+
+	_, end, err := bencodeString(content, start)
+	return end, err
+}
+
+func infoHashFromMagnet(link string) (string, error) {
+	if !strings.HasPrefix(link, magnetPrefix) {
+		return "", fmt.Errorf("Not a magnet link: %s", link)
+	}
+
+	query, err := url.ParseQuery(strings.TrimPrefix(link[len(magnetPrefix):], "?"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, topic := range query["xt"] {
+		if !strings.HasPrefix(topic, btihPrefix) {
+			continue
+		}
+
+		hash := topic[len(btihPrefix):]
+		switch len(hash) {
+		case 40:
+			if _, err := hex.DecodeString(hash); err != nil {
+				return "", fmt.Errorf("Invalid info hash %s", hash)
+			}
+			return strings.ToLower(hash), nil
+		case 32:
+			decoded, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+			if err != nil {
+				return "", fmt.Errorf("Invalid info hash %s", hash)
+			}
+			return hex.EncodeToString(decoded), nil
+		}
+		return "", fmt.Errorf("Invalid info hash %s", hash)
+	}
+
+	return "", fmt.Errorf("Magnet link has no info hash")
+}
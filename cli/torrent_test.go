@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"testing"
+)
+
+func TestInfoHashFromTorrent(t *testing.T) {
+	info := "d6:lengthi5e4:name9:movie.mkv12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae"
+	sum := sha1.Sum([]byte(info))
+	hash := hex.EncodeToString(sum[:])
+
+	// Keys out of order have to be hashed as they are, not sorted
+	unsorted := sha1.Sum([]byte("d4:name9:movie.mkv6:lengthi5ee"))
+	unsortedHash := hex.EncodeToString(unsorted[:])
+
+	tests := []struct {
+		content string
+		want    string
+		wantErr bool
+	}{
+		{content: "d8:announce14:http://tracker4:info" + info + "e", want: hash},
+		{content: "d4:info" + info + "8:announce14:http://trackere", want: hash},
+		{content: "d4:infod4:name9:movie.mkv6:lengthi5eee", want: unsortedHash},
+		{content: "d4:infol1:ae8:announce14:http://trackere", wantErr: true},
+		{content: "d4:infod4:name9:movie.mkve", wantErr: true},
+		{content: "d4:infod4:name99:movie.mkvee", wantErr: true},
+		{content: "d8:announce14:http://trackere", wantErr: true},
+		{content: "l4:infoe", wantErr: true},
+		{content: "not a torrent", wantErr: true},
+		{content: "", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := infoHashFromTorrent([]byte(test.content))
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("infoHashFromTorrent(%q) = %q, want an error", test.content, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("infoHashFromTorrent(%q) failed: %s", test.content, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("infoHashFromTorrent(%q) = %q, want %q", test.content, got, test.want)
+		}
+	}
+}
+
+func TestInfoHashFromMagnet(t *testing.T) {
+	const hash = "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	const base32Hash = "YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK"
+
+	tests := []struct {
+		link    string
+		want    string
+		wantErr bool
+	}{
+		{link: "magnet:?xt=urn:btih:" + hash, want: hash},
+		{link: "magnet:?xt=urn:btih:C12FE1C06BBA254A9DC9F519B335AA7C1367A88A&dn=movie", want: hash},
+		{link: "magnet:?xt=urn:btih:" + base32Hash, want: hash},
+		{link: "magnet:?xt=urn:btih:yex6dqdlxisuvhoj6um3gnnkpqjwpkek", want: hash},
+		{link: "magnet:?dn=movie&xt=urn:sha1:abc&xt=urn:btih:" + hash, want: hash},
+		{link: "magnet:?xt=urn:btih:" + hash[:39] + "g", wantErr: true},
+		{link: "magnet:?xt=urn:btih:" + base32Hash[:31] + "1", wantErr: true},
+		{link: "magnet:?xt=urn:btih:abc", wantErr: true},
+		{link: "magnet:?dn=movie", wantErr: true},
+		{link: "http://example.com/movie.torrent", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := infoHashFromMagnet(test.link)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("infoHashFromMagnet(%q) = %q, want an error", test.link, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("infoHashFromMagnet(%q) failed: %s", test.link, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("infoHashFromMagnet(%q) = %q, want %q", test.link, got, test.want)
+		}
+	}
+}
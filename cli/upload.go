@@ -1,13 +1,16 @@
 package cli
 
 import (
+	"fmt"
 	"strings"
 )
 
 func (c *Cli) Upload(link string) {
 	if strings.HasPrefix(link, "magnet") {
-		c.premiumize.UploadMagnetLink(link)
-	} else {
-		c.premiumize.UploadTorrentFile(link)
+		c.premiumize.UploadMagnetLink(link, "")
+	} else if _, err := c.premiumize.UploadTorrentFile(link, ""); err == nil {
+		if _, err := archiveTorrent(link); err != nil {
+			fmt.Printf("Could not archive %s: %s\n", link, err.Error())
+		}
 	}
 }
@@ -35,6 +35,23 @@ var knownVideoFileExtensions = []string{
 	"f4b",
 }
 
+var knownAudioFileExtensions = []string{
+	"mp3",
+	"flac",
+	"ogg",
+	"oga",
+	"opus",
+	"m4a",
+	"aac",
+	"wav",
+	"wma",
+	"aiff",
+	"ape",
+	"wv",
+	"mka",
+	"alac",
+}
+
 var videoFileBlacklist = []string{
 	"sample", "RAGB",
 }
@@ -48,6 +65,15 @@ func isKnownVideoFileExtension(extension string) bool {
 	return false
 }
 
+func isKnownAudioFileExtension(extension string) bool {
+	for _, ext := range knownAudioFileExtensions {
+		if ext == extension {
+			return true
+		}
+	}
+	return false
+}
+
 func isAllowedVideoFile(name string) bool {
 	for _, word := range videoFileBlacklist {
 		if strings.Contains(strings.ToLower(name), word) {
@@ -0,0 +1,318 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"github.com/jackpal/bencode-go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// torrentArchiveDirectory keeps the uploaded torrent and magnet files, named
+// after their info hash, next to the database.
+const torrentArchiveDirectory = "pget-torrents"
+
+// archiveTorrent keeps a copy of an uploaded torrent or magnet file, so its
+// transfer can be verified after download, and returns the path of the copy.
+func archiveTorrent(filePath string) (string, error) {
+	hash, err := infoHash(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	extension := ".magnet"
+	if strings.HasSuffix(filePath, ".torrent") {
+		extension = ".torrent"
+	}
+
+	if err := os.MkdirAll(torrentArchiveDirectory, 0770); err != nil {
+		return "", err
+	}
+	archived := filepath.Join(torrentArchiveDirectory, hash+extension)
+	return archived, ioutil.WriteFile(archived, content, 0644)
+}
+
+// archivedTorrent returns the archived torrent file of an info hash. Transfers
+// uploaded as magnet links have none.
+func archivedTorrent(hash string) ([]byte, error) {
+	content, err := ioutil.ReadFile(filepath.Join(torrentArchiveDirectory, strings.ToLower(hash)+".torrent"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("No torrent file archived for %s", hash)
+	}
+	return content, err
+}
+
+// archivedUpload returns the archived torrent or magnet file of an info hash.
+func archivedUpload(hash string) (string, error) {
+	for _, extension := range []string{".torrent", ".magnet"} {
+		path := filepath.Join(torrentArchiveDirectory, strings.ToLower(hash)+extension)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("No torrent or magnet file archived for %s", hash)
+}
+
+// torrentFile is a file of a torrent, located at Offset in the concatenation
+// of all files the pieces are hashed over.
+type torrentFile struct {
+	Path   string
+	Length int64
+	Offset int64
+}
+
+// maxPieceLength limits the buffer allocated for verification. Real torrents
+// use far smaller pieces.
+const maxPieceLength = 64 << 20
+
+type torrentMetainfo struct {
+	PieceLength int64
+	Pieces      []byte
+	Files       []torrentFile
+}
+
+func parseMetainfo(content []byte) (torrentMetainfo, error) {
+	var metainfo struct {
+		Info struct {
+			Name        string `bencode:"name"`
+			PieceLength int64  `bencode:"piece length"`
+			Pieces      string `bencode:"pieces"`
+			Length      int64  `bencode:"length"`
+			Files       []struct {
+				Length int64    `bencode:"length"`
+				Path   []string `bencode:"path"`
+			} `bencode:"files"`
+		} `bencode:"info"`
+	}
+	if err := bencode.Unmarshal(bytes.NewReader(content), &metainfo); err != nil {
+		return torrentMetainfo{}, err
+	}
+
+	info := metainfo.Info
+	if info.PieceLength <= 0 || info.PieceLength > maxPieceLength {
+		return torrentMetainfo{}, fmt.Errorf("Torrent file has an invalid piece length")
+	}
+	if !isTorrentPathElement(info.Name) {
+		return torrentMetainfo{}, fmt.Errorf("Illegal name %s in torrent file", info.Name)
+	}
+
+	result := torrentMetainfo{PieceLength: info.PieceLength, Pieces: []byte(info.Pieces)}
+	if len(info.Files) == 0 {
+		result.Files = []torrentFile{{Path: info.Name, Length: info.Length}}
+	}
+
+	var offset int64
+	for _, file := range info.Files {
+		for _, element := range file.Path {
+			if !isTorrentPathElement(element) {
+				return torrentMetainfo{}, fmt.Errorf("Illegal path %s in torrent file", strings.Join(file.Path, "/"))
+			}
+		}
+		if len(file.Path) == 0 {
+			return torrentMetainfo{}, fmt.Errorf("Torrent file has a file without path")
+		}
+
+		result.Files = append(result.Files, torrentFile{
+			Path:   strings.Join(append([]string{info.Name}, file.Path...), "/"),
+			Length: file.Length,
+			Offset: offset,
+		})
+		offset += file.Length
+	}
+
+	var total int64
+	for _, file := range result.Files {
+		if file.Length < 0 {
+			return torrentMetainfo{}, fmt.Errorf("Torrent file has a negative file length")
+		}
+		total += file.Length
+	}
+	pieces := (total + info.PieceLength - 1) / info.PieceLength
+	if int64(len(info.Pieces)) != pieces*sha1.Size {
+		return torrentMetainfo{}, fmt.Errorf("Torrent file has %d piece hashes for %d pieces", len(info.Pieces)/sha1.Size, pieces)
+	}
+	return result, nil
+}
+
+// isTorrentPathElement rejects names and path elements of a torrent file that
+// would lead outside of the download directory.
+func isTorrentPathElement(element string) bool {
+	return element != "" && element != "." && element != ".." && !strings.ContainsAny(element, "/\\") && !filepath.IsAbs(element)
+}
+
+// verifyResult lists the files of a torrent by their verification outcome.
+// Files which are not on disk, e.g. because of filters, are neither verified
+// nor considered corrupt.
+type verifyResult struct {
+	Verified []string
+	Corrupt  []string
+	Missing  []string
+}
+
+// verifyFiles hashes the local files of a torrent, laid out below root the way
+// they are downloaded, against the pieces of its metainfo. Pieces overlapping
+// files which are not on disk can not be checked; files only covered by such
+// pieces count as verified if their size is right.
+func verifyFiles(metainfo torrentMetainfo, root string, flatten bool) verifyResult {
+	paths := make([]string, len(metainfo.Files))
+	present := make([]bool, len(metainfo.Files))
+	corrupt := make([]bool, len(metainfo.Files))
+	for i, file := range metainfo.Files {
+		paths[i] = localTorrentPath(root, file.Path, flatten)
+		stat, err := os.Stat(paths[i])
+		if err != nil {
+			continue
+		}
+		present[i] = true
+		corrupt[i] = stat.Size() != file.Length
+	}
+
+	handles := make(map[int]*os.File)
+	defer func() {
+		for _, handle := range handles {
+			handle.Close()
+		}
+	}()
+
+	var total int64
+	if count := len(metainfo.Files); count > 0 {
+		total = metainfo.Files[count-1].Offset + metainfo.Files[count-1].Length
+	}
+
+	first := 0
+	buffer := make([]byte, metainfo.PieceLength)
+	for piece := 0; int64(piece)*metainfo.PieceLength < total; piece++ {
+		start := int64(piece) * metainfo.PieceLength
+		end := start + metainfo.PieceLength
+		if end > total {
+			end = total
+		}
+
+		for first < len(metainfo.Files) && metainfo.Files[first].Offset+metainfo.Files[first].Length <= start {
+			first++
+		}
+		var overlapping []int
+		for i := first; i < len(metainfo.Files) && metainfo.Files[i].Offset < end; i++ {
+			overlapping = append(overlapping, i)
+		}
+
+		checkable := true
+		for _, i := range overlapping {
+			checkable = checkable && present[i] && !corrupt[i]
+		}
+		if !checkable {
+			continue
+		}
+
+		data := buffer[:end-start]
+		err := readPiece(metainfo.Files, paths, handles, overlapping, start, data)
+		sum := sha1.Sum(data)
+		if err != nil || !bytes.Equal(sum[:], metainfo.Pieces[piece*sha1.Size:(piece+1)*sha1.Size]) {
+			for _, i := range overlapping {
+				corrupt[i] = true
+			}
+		}
+	}
+
+	var result verifyResult
+	for i := range metainfo.Files {
+		switch {
+		case !present[i]:
+			result.Missing = append(result.Missing, paths[i])
+		case corrupt[i]:
+			result.Corrupt = append(result.Corrupt, paths[i])
+		default:
+			result.Verified = append(result.Verified, paths[i])
+		}
+	}
+	return result
+}
+
+func readPiece(files []torrentFile, paths []string, handles map[int]*os.File, overlapping []int, start int64, data []byte) error {
+	end := start + int64(len(data))
+	for _, i := range overlapping {
+		handle, ok := handles[i]
+		if !ok {
+			var err error
+			if handle, err = os.Open(paths[i]); err != nil {
+				return err
+			}
+			handles[i] = handle
+		}
+
+		from, to := files[i].Offset, files[i].Offset+files[i].Length
+		if from < start {
+			from = start
+		}
+		if to > end {
+			to = end
+		}
+		if _, err := handle.ReadAt(data[from-start:to-start], from-files[i].Offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localTorrentPath returns where a file of a torrent is downloaded to, matching
+// the layout of createDownloadList.
+func localTorrentPath(root string, torrentPath string, flatten bool) string {
+	if flatten {
+		return filepath.Join(root, extractFileName(torrentPath))
+	}
+	return filepath.Join(root, filepath.FromSlash(torrentPath))
+}
+
+// verifyTransfer checks the downloaded files of a transfer against its
+// archived torrent file.
+func verifyTransfer(hash string, root string, flatten bool) (verifyResult, error) {
+	content, err := archivedTorrent(hash)
+	if err != nil {
+		return verifyResult{}, err
+	}
+
+	metainfo, err := parseMetainfo(content)
+	if err != nil {
+		return verifyResult{}, err
+	}
+	return verifyFiles(metainfo, root, flatten), nil
+}
+
+// Verify checks the downloaded files of a transfer against the pieces of the
+// torrent file it has been uploaded with.
+func (c *Cli) Verify(name string, targetDirectory string, flatten bool) error {
+	transfer, err := c.premiumize.FindTorrentByName(name)
+	if err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
+
+	result, err := verifyTransfer(transfer.Hash, targetDirectory, flatten)
+	if err != nil {
+		fmt.Printf("Unable to verify %s: %s\n", transfer.Name, err.Error())
+		return err
+	}
+
+	for _, path := range result.Verified {
+		fmt.Printf("* %s [ok]\n", path)
+	}
+	for _, path := range result.Corrupt {
+		fmt.Printf("* %s [corrupt]\n", path)
+	}
+	for _, path := range result.Missing {
+		fmt.Printf("* %s [missing]\n", path)
+	}
+
+	if len(result.Corrupt) > 0 {
+		return fmt.Errorf("%d corrupt files in %s", len(result.Corrupt), transfer.Name)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"github.com/jackpal/bencode-go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testTorrent returns a torrent file with the given info dictionary.
+func testTorrent(t *testing.T, info map[string]interface{}) []byte {
+	var buffer bytes.Buffer
+	if err := bencode.Marshal(&buffer, map[string]interface{}{"info": info}); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func testFiles(files ...interface{}) []interface{} {
+	var list []interface{}
+	for i := 0; i < len(files); i += 2 {
+		list = append(list, map[string]interface{}{"length": files[i], "path": files[i+1]})
+	}
+	return list
+}
+
+func TestParseMetainfo(t *testing.T) {
+	onePiece := strings.Repeat("a", sha1.Size)
+	twoPieces := strings.Repeat("a", 2*sha1.Size)
+
+	tests := []struct {
+		description string
+		info        map[string]interface{}
+		want        []torrentFile
+		wantErr     bool
+	}{
+		{
+			description: "single file",
+			info:        map[string]interface{}{"name": "movie.mkv", "piece length": 16, "pieces": twoPieces, "length": 20},
+			want:        []torrentFile{{Path: "movie.mkv", Length: 20}},
+		},
+		{
+			description: "multiple files",
+			info: map[string]interface{}{"name": "movie", "piece length": 16, "pieces": twoPieces, "files": testFiles(
+				10, []string{"movie.mkv"},
+				6, []string{"extra", "sample.mkv"},
+				1, []string{"info.nfo"},
+			)},
+			want: []torrentFile{
+				{Path: "movie/movie.mkv", Length: 10},
+				{Path: "movie/extra/sample.mkv", Length: 6, Offset: 10},
+				{Path: "movie/info.nfo", Length: 1, Offset: 16},
+			},
+		},
+		{
+			description: "empty file",
+			info:        map[string]interface{}{"name": "empty", "piece length": 16, "pieces": "", "length": 0},
+			want:        []torrentFile{{Path: "empty"}},
+		},
+		{
+			description: "zero piece length",
+			info:        map[string]interface{}{"name": "movie.mkv", "piece length": 0, "pieces": onePiece, "length": 20},
+			wantErr:     true,
+		},
+		{
+			description: "huge piece length",
+			info:        map[string]interface{}{"name": "movie.mkv", "piece length": maxPieceLength + 1, "pieces": onePiece, "length": 20},
+			wantErr:     true,
+		},
+		{
+			description: "too few piece hashes",
+			info:        map[string]interface{}{"name": "movie.mkv", "piece length": 16, "pieces": onePiece, "length": 20},
+			wantErr:     true,
+		},
+		{
+			description: "too many piece hashes",
+			info:        map[string]interface{}{"name": "movie.mkv", "piece length": 32, "pieces": twoPieces, "length": 20},
+			wantErr:     true,
+		},
+		{
+			description: "truncated piece hash",
+			info:        map[string]interface{}{"name": "movie.mkv", "piece length": 32, "pieces": onePiece[1:], "length": 20},
+			wantErr:     true,
+		},
+		{
+			description: "negative length",
+			info:        map[string]interface{}{"name": "movie.mkv", "piece length": 16, "pieces": "", "length": -20},
+			wantErr:     true,
+		},
+		{
+			description: "parent directory as name",
+			info:        map[string]interface{}{"name": "..", "piece length": 16, "pieces": twoPieces, "length": 20},
+			wantErr:     true,
+		},
+		{
+			description: "name with a slash",
+			info:        map[string]interface{}{"name": "../movie.mkv", "piece length": 16, "pieces": twoPieces, "length": 20},
+			wantErr:     true,
+		},
+		{
+			description: "absolute name",
+			info:        map[string]interface{}{"name": "/movie.mkv", "piece length": 16, "pieces": twoPieces, "length": 20},
+			wantErr:     true,
+		},
+		{
+			description: "empty name",
+			info:        map[string]interface{}{"name": "", "piece length": 16, "pieces": twoPieces, "length": 20},
+			wantErr:     true,
+		},
+		{
+			description: "parent directory in a path",
+			info: map[string]interface{}{"name": "movie", "piece length": 16, "pieces": twoPieces, "files": testFiles(
+				20, []string{"..", "..", "movie.mkv"},
+			)},
+			wantErr: true,
+		},
+		{
+			description: "backslash in a path",
+			info: map[string]interface{}{"name": "movie", "piece length": 16, "pieces": twoPieces, "files": testFiles(
+				20, []string{"..\\movie.mkv"},
+			)},
+			wantErr: true,
+		},
+		{
+			description: "empty path",
+			info: map[string]interface{}{"name": "movie", "piece length": 16, "pieces": twoPieces, "files": testFiles(
+				20, []string{},
+			)},
+			wantErr: true,
+		},
+		{
+			description: "negative file length",
+			info: map[string]interface{}{"name": "movie", "piece length": 16, "pieces": twoPieces, "files": testFiles(
+				40, []string{"movie.mkv"},
+				-20, []string{"info.nfo"},
+			)},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		metainfo, err := parseMetainfo(testTorrent(t, test.info))
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: parsed %+v, want an error", test.description, metainfo)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed: %s", test.description, err)
+			continue
+		}
+		if !reflect.DeepEqual(metainfo.Files, test.want) {
+			t.Errorf("%s: files %+v, want %+v", test.description, metainfo.Files, test.want)
+		}
+	}
+
+	if _, err := parseMetainfo([]byte("not a torrent")); err == nil {
+		t.Errorf("parsed an invalid torrent file")
+	}
+}
+
+func TestVerifyFiles(t *testing.T) {
+	// Piece aligned files, so a corrupt file does not affect the others.
+	files := map[string]string{"a.mkv": "aaaa", "extra/b.mkv": "bbbb", "c.nfo": "ccc"}
+	var pieces string
+	for _, piece := range []string{"aaaa", "bbbb", "ccc"} {
+		sum := sha1.Sum([]byte(piece))
+		pieces += string(sum[:])
+	}
+	content := testTorrent(t, map[string]interface{}{"name": "movie", "piece length": 4, "pieces": pieces, "files": testFiles(
+		4, []string{"a.mkv"},
+		4, []string{"extra", "b.mkv"},
+		3, []string{"c.nfo"},
+	)})
+	metainfo, err := parseMetainfo(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		flatten     bool
+		change      map[string]string
+		verified    []string
+		corrupt     []string
+		missing     []string
+	}{
+		{
+			description: "complete",
+			verified:    []string{"movie/a.mkv", "movie/c.nfo", "movie/extra/b.mkv"},
+		},
+		{
+			description: "flattened",
+			flatten:     true,
+			verified:    []string{"a.mkv", "b.mkv", "c.nfo"},
+		},
+		{
+			description: "corrupt content",
+			change:      map[string]string{"extra/b.mkv": "bxbb"},
+			verified:    []string{"movie/a.mkv", "movie/c.nfo"},
+			corrupt:     []string{"movie/extra/b.mkv"},
+		},
+		{
+			description: "wrong size",
+			change:      map[string]string{"a.mkv": "aaaaa"},
+			verified:    []string{"movie/c.nfo", "movie/extra/b.mkv"},
+			corrupt:     []string{"movie/a.mkv"},
+		},
+		{
+			description: "missing file",
+			change:      map[string]string{"c.nfo": ""},
+			verified:    []string{"movie/a.mkv", "movie/extra/b.mkv"},
+			missing:     []string{"movie/c.nfo"},
+		},
+	}
+
+	for _, test := range tests {
+		root, err := ioutil.TempDir("", "pget-verify")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, data := range files {
+			if changed, ok := test.change[name]; ok {
+				if changed == "" {
+					continue
+				}
+				data = changed
+			}
+			path := filepath.Join(root, "movie", filepath.FromSlash(name))
+			if test.flatten {
+				path = filepath.Join(root, filepath.Base(name))
+			}
+			os.MkdirAll(filepath.Dir(path), 0755)
+			if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		result := verifyFiles(metainfo, root, test.flatten)
+		for _, check := range []struct {
+			kind string
+			got  []string
+			want []string
+		}{
+			{"verified", result.Verified, test.verified},
+			{"corrupt", result.Corrupt, test.corrupt},
+			{"missing", result.Missing, test.missing},
+		} {
+			if got := relativePaths(t, root, check.got); !reflect.DeepEqual(got, check.want) {
+				t.Errorf("%s: %s %v, want %v", test.description, check.kind, got, check.want)
+			}
+		}
+		os.RemoveAll(root)
+	}
+}
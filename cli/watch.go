@@ -1,13 +1,14 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"github.com/boltdb/bolt"
-	"github.com/fsnotify/fsnotify"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"pget/poller"
 	"pget/premiumize"
 	"pget/watcher"
 	"strings"
@@ -17,22 +18,34 @@ import (
 const premiumizeFinishedStatus = "finished"
 const boltDBFile = "pget.db"
 const torrentsBucket = "torrents"
+const migrationsBucket = "migrations"
+const uploadLocationsMigration = "upload_locations"
+const torrentFilePattern = ".*?\\.torrent"
 
+// openBoltDB locks the database for this watch instance and makes sure it can
+// be used. The database itself is only opened while it is accessed, so other
+// commands can use it meanwhile.
 func (c *Cli) openBoltDB() error {
 	c.boltMutex.Lock()
-	defer c.boltMutex.Unlock()
-
-	if c.bolt == nil {
-		db, err := bolt.Open(boltDBFile, 0600, nil)
+	if c.boltLock == nil {
+		lock, err := acquireLock(boltDBFile + ".lock")
 		if err != nil {
+			c.boltMutex.Unlock()
 			return err
 		}
-		c.bolt = db
+		c.boltLock = lock
 	}
-	return nil
+	c.boltMutex.Unlock()
+
+	return c.update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(torrentsBucket))
+		return err
+	})
 }
 
-func (c *Cli) WatchAndUpload(directory string, strict bool, deleteAfterUpload bool) {
+// WatchAndUpload uploads torrent files dropped into the directory until the
+// context is done. Dropped files are moved into the torrent archive.
+func (c *Cli) WatchAndUpload(ctx context.Context, directory string, strict bool, onlyCached bool, recheckInterval int, maxActive int) {
 	stat, err := os.Stat(directory)
 	if err != nil {
 		fmt.Printf("Unable to retrieve directory stats: %s\n", err.Error())
@@ -48,133 +61,507 @@ func (c *Cli) WatchAndUpload(directory string, strict bool, deleteAfterUpload bo
 		fmt.Printf("Unable to open database for upload/download tracking: %s\n", err.Error())
 		return
 	}
-	c.bolt.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(torrentsBucket))
-		return err
-	})
-
-	watcher := watcher.New(watcher.FileWatcherConfig{
+	fileWatcher := watcher.New(watcher.FileWatcherConfig{
 		BaseDir:      directory,
-		MatchPattern: ".*?\\.torrent",
+		MatchPattern: torrentFilePattern,
+		Exclude:      []string{pendingPattern(directory)},
 		ScanInterval: 5 * time.Second,
+		Polling:      c.watcherPolling,
+		QuietPeriod:  c.watcherQuietPeriod,
 	})
 
-	pathCh := make(chan string)
-	watcher.AddWatcher(pathCh)
-	watcher.Run()
+	eventCh := make(chan watcher.Event)
+	errorCh := make(chan error)
+	fileWatcher.AddWatcher(eventCh)
+	fileWatcher.AddErrorWatcher(errorCh)
+	fileWatcher.Run(ctx)
+	defer fileWatcher.Stop()
 
-	var path string
-	for {
-		path = <-pathCh
-		c.processTorrentFile(directory, path, strict, deleteAfterUpload)
+	if onlyCached {
+		if recheckInterval < 1 {
+			recheckInterval = 1
+		}
+		go c.recheckPending(ctx, directory, time.Duration(recheckInterval)*time.Minute)
 	}
-}
 
-func (c *Cli) setupWatcher(watcher *fsnotify.Watcher, directory string) error {
-	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
-		if !info.IsDir() {
-			return nil
+	queued := make(chan struct{}, 1)
+	go c.uploadQueue(ctx, queued, maxActive)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-eventCh:
+			if event.Op == watcher.Created && c.processTorrentFile(directory, event.Path, strict, onlyCached) {
+				select {
+				case queued <- struct{}{}:
+				default:
+				}
+			}
+		case err := <-errorCh:
+			fmt.Printf("Error while watching %s: %s\n", directory, err.Error())
 		}
+	}
+}
 
-		err = watcher.Add(path)
+// processTorrentFile adds a torrent or magnet file to the upload queue and
+// returns whether it has been queued. Files of torrents which are queued
+// already are dropped.
+func (c *Cli) processTorrentFile(basePath string, filePath string, strict bool, onlyCached bool) bool {
+	if onlyCached {
+		cached, err := c.isCached(filePath)
 		if err != nil {
-			fmt.Printf("Failed to add directory %s to watcher: %s\n", path, err.Error())
+			fmt.Printf("Unable to check if %s is cached: %s\n", filePath, err.Error())
+			return false
 		}
-		fmt.Printf("Watching %s\n", path)
-		return nil
-	})
-
-	return err
-}
+		if !cached {
+			c.moveToPending(basePath, filePath)
+			return false
+		}
+	}
 
-func (c *Cli) processTorrentFile(basePath string, filePath string, strict bool, deleteAfterUpload bool) {
 	location := extractLocation(basePath, filePath)
 
 	// TODO: Check if torrent is already in our db
-	// TODO: Check torrent file integrity
-
-	resp, err := c.upload(filePath)
 
+	archived, err := archiveTorrent(filePath)
 	if err != nil {
-		fmt.Printf("Failed to upload %s: %s\n", filePath, err.Error())
-	} else {
-		err := os.Remove(filePath)
-		if err != nil {
-			fmt.Printf("Could not delete torrent file after processing: %s\n", err.Error())
-		}
-		err = c.bolt.Update(func(tx *bolt.Tx) error {
-			bucket := tx.Bucket([]byte(torrentsBucket))
-			err := bucket.Put([]byte(resp.ID), []byte(location))
-			return err
-		})
-		if err != nil {
-			fmt.Printf("Failed to store torrent %s in database, this torrent will not be automatically downloaded: %s\n", filePath, err.Error())
-		}
+		fmt.Printf("Unable to queue %s: %s\n", filePath, err.Error())
+		return false
+	}
+	err = c.enqueue(archived, uploadName(filePath), location)
+	if err != nil && err != errAlreadyQueued {
+		fmt.Printf("Unable to queue %s: %s\n", filePath, err.Error())
+		return false
 	}
+	if err == errAlreadyQueued {
+		fmt.Printf("%s is queued already\n", filePath)
+	}
+
+	if err := os.Remove(filePath); err != nil {
+		fmt.Printf("Could not delete torrent file after processing: %s\n", err.Error())
+	}
+	return err == nil
 }
 
-func (c *Cli) upload(filePath string) (premiumize.UploadResponse, error) {
+func (c *Cli) upload(filePath string, folderID string) (premiumize.UploadResponse, error) {
 	if strings.HasSuffix(filePath, ".torrent") {
-		return c.premiumize.UploadTorrentFile(filePath)
+		return c.premiumize.UploadTorrentFile(filePath, folderID)
 	} else {
 		content, err := ioutil.ReadFile(filePath)
 		if err != nil {
 			return premiumize.UploadResponse{}, err
 		}
-		return c.premiumize.UploadMagnetLink(string(content))
+		return c.premiumize.UploadMagnetLink(string(content), folderID)
 	}
-	return premiumize.UploadResponse{}, nil
 }
 
+// extractLocation returns the subfolder of the upload directory a file has
+// been dropped into, separated by forward slashes. Files placed directly in
+// the upload directory have no location.
 func extractLocation(basePath string, filePath string) string {
-	path := filePath[len(basePath)+1:]
+	location, err := filepath.Rel(basePath, filepath.Dir(filePath))
+	if err != nil || location == "." {
+		return ""
+	}
+	return filepath.ToSlash(location)
+}
+
+// MigrateUploadLocations rewrites the upload locations stored by earlier
+// versions, which kept the directory of the torrent file including the upload
+// directory, into subfolders of the upload directory. It runs only once per
+// database.
+func (c *Cli) MigrateUploadLocations(directory string) {
+	err := c.update(func(tx *bolt.Tx) error {
+		migrations, err := tx.CreateBucketIfNotExists([]byte(migrationsBucket))
+		if err != nil {
+			return err
+		}
+		if migrations.Get([]byte(uploadLocationsMigration)) != nil {
+			return nil
+		}
 
-	if strings.ContainsRune(path, os.PathSeparator) {
-		file := filepath.Base(path)
-		return filePath[:len(filePath)-len(file)-1]
+		if bucket := tx.Bucket([]byte(torrentsBucket)); bucket != nil {
+			migrated := make(map[string]string)
+			bucket.ForEach(func(k, v []byte) error {
+				if location, ok := legacyLocation(directory, string(v)); ok {
+					migrated[string(k)] = location
+				}
+				return nil
+			})
+
+			for id, location := range migrated {
+				if err := bucket.Put([]byte(id), []byte(location)); err != nil {
+					return err
+				}
+			}
+			if len(migrated) > 0 {
+				fmt.Printf("Migrated the upload locations of %d transfers\n", len(migrated))
+			}
+		}
+
+		return migrations.Put([]byte(uploadLocationsMigration), []byte(time.Now().Format(time.RFC3339)))
+	})
+	if err != nil {
+		fmt.Printf("Unable to migrate upload locations: %s\n", err.Error())
+	}
+}
+
+// legacyLocation converts a location stored by earlier versions. It reports
+// false for locations which are subfolders already.
+func legacyLocation(directory string, stored string) (string, bool) {
+	bases := []string{filepath.Clean(directory)}
+	if abs, err := filepath.Abs(directory); err == nil {
+		bases = append(bases, abs)
 	}
-	return ""
+
+	for _, base := range bases {
+		if stored == base || strings.HasPrefix(stored, base+string(filepath.Separator)) {
+			return extractLocation(base, filepath.Join(stored, "file")), true
+		}
+	}
+	return "", false
+}
+
+// isUploadLocation reports whether a stored location is a subfolder of the
+// upload directory and can be used below the download directory.
+func isUploadLocation(location string) bool {
+	if location == "" {
+		return true
+	}
+	if filepath.IsAbs(location) || strings.HasPrefix(location, "/") {
+		return false
+	}
+	for _, element := range strings.Split(location, "/") {
+		if element == "" || element == "." || element == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// DownloadWatchConfig holds the settings of the download side of watch mode.
+type DownloadWatchConfig struct {
+	Directory string
+	// Defaults are the rules for transfers without a configured category.
+	Defaults Category
+	// Strict only downloads transfers that have been uploaded by pget.
+	Strict bool
+	// SyncFile creates a .sync file in Directory during every download cycle.
+	SyncFile bool
+	// Manifest writes a completion manifest for every downloaded transfer.
+	Manifest bool
+	// Verify checks downloaded files against the archived torrent file.
+	Verify bool
+	// DeleteGracePeriod delays the deletion of downloaded transfers, which
+	// can be cancelled with undelete meanwhile.
+	DeleteGracePeriod time.Duration
+	// PollInterval is how often transfers are checked while they change,
+	// backing off up to 10 minutes otherwise.
+	PollInterval time.Duration
 }
 
-func (c *Cli) WatchAndDownload(targetDirectory string, videoOnly bool, flatten bool, strict bool, deleteDownloaded bool, createSyncFile bool, delay int) {
-	if strict {
-		if err := c.openBoltDB(); err != nil {
-			fmt.Printf("Unable to open database for upload/download tracking: %s\n", err.Error())
+// WatchAndDownload downloads transfers as soon as the transfer poller reports
+// them finished, until the context is done. Running downloads get the
+// shutdown timeout to finish.
+func (c *Cli) WatchAndDownload(ctx context.Context, config DownloadWatchConfig) {
+	if err := c.openBoltDB(); err != nil {
+		fmt.Printf("Unable to open database for upload/download tracking: %s\n", err.Error())
+		if config.Strict {
 			return
 		}
 	}
 
-	done := make(chan bool)
-	go func() {
-		for {
-			if createSyncFile {
-				c.createSyncFile(targetDirectory)
-			}
+	if err := os.MkdirAll(config.Directory, 0770); err != nil {
+		fmt.Printf("Unable to create download directory: %s\n", err.Error())
+		return
+	}
+
+	lock, err := acquireLock(filepath.Join(config.Directory, lockFileName))
+	if err != nil {
+		fmt.Printf("Unable to lock download directory: %s\n", err.Error())
+		return
+	}
+	defer lock.Release()
+
+	transferPoller := poller.New(poller.TransferPollerConfig{
+		List:        c.premiumize.ListTorrents,
+		MinInterval: config.PollInterval,
+	})
+
+	eventCh := make(chan poller.Event)
+	notifyCh := make(chan poller.Event)
+	errorCh := make(chan error)
+	transferPoller.AddWatcher(eventCh)
+	transferPoller.AddWatcher(notifyCh)
+	transferPoller.AddErrorWatcher(errorCh)
+	transferPoller.Run(ctx)
+	defer transferPoller.Stop()
+
+	go c.notifyTransferEvents(ctx, notifyCh, config)
+
+	// Finished transfers are downloaded after every poll in the configured
+	// order. Transfers whose download failed or fell outside of the download
+	// windows or budgets are retried after the next poll.
+	pending := make(map[string]premiumize.TorrentItem)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-errorCh:
+			fmt.Printf("Could not retrieve list of torrents: %s\n", err.Error())
+		case event := <-eventCh:
+			switch event.Op {
+			case poller.Finished:
+				if !c.isDownloaded(event.Transfer.ID) {
+					pending[event.Transfer.ID] = event.Transfer
+				}
+			case poller.Removed:
+				if _, ok := pending[event.Transfer.ID]; ok {
+					delete(pending, event.Transfer.ID)
+					c.unprioritize(event.Transfer.ID)
+				}
+			case poller.Polled:
+				c.deleteDue(event.Transfers)
+				if c.stalledConfig.enabled() {
+					c.checkStalled(event.Transfers)
+				}
+
+				c.downloadInOrder(ctx, pending, event.Transfers, config)
 
-			torrents, err := c.premiumize.ListTorrents()
-			if err != nil {
-				fmt.Printf("Could not retrieve list of torrents: %s\n", err.Error())
-			} else {
-				for _, transfer := range torrents.Transfers {
-					isFinished := c.isTorrentFinished(transfer.Status)
-					hasBeenUploaded := c.hasBeenUploadedWhenStrict(strict, transfer)
-
-					if isFinished && hasBeenUploaded {
-						id, err := c.DownloadTorrent(transfer.Name, targetDirectory, videoOnly, flatten, "")
-						if deleteDownloaded && err == nil {
-							c.premiumize.DeleteTorrent(id)
-						}
-					}
+				if c.cleanupConfig.enabled() && ctx.Err() == nil {
+					c.cleanup(event.Transfers, pending, false)
 				}
 			}
-			if createSyncFile {
-				c.deleteSyncFile(targetDirectory)
+		}
+	}
+}
+
+// downloadInOrder downloads pending transfers in the configured order. The
+// order is evaluated again after every download, so prioritized transfers
+// jump ahead. Once a poll interval has passed, the remaining transfers wait
+// for the next poll, which also picks up newly finished ones.
+func (c *Cli) downloadInOrder(ctx context.Context, pending map[string]premiumize.TorrentItem, listed []premiumize.TorrentItem, config DownloadWatchConfig) {
+	if len(pending) == 0 {
+		return
+	}
+
+	seen, err := c.firstSeen(listed)
+	if err != nil {
+		fmt.Printf("Could not track transfers: %s\n", err.Error())
+	}
+
+	started := time.Now()
+	attempted := make(map[string]bool)
+	for ctx.Err() == nil {
+		var remaining []premiumize.TorrentItem
+		for id, transfer := range pending {
+			if !attempted[id] {
+				remaining = append(remaining, transfer)
+			}
+		}
+		if len(remaining) == 0 {
+			return
+		}
+
+		transfer := c.orderTransfers(remaining, listed, seen, config.Defaults)[0]
+		attempted[transfer.ID] = true
+
+		err := c.downloadPending(ctx, transfer, config)
+		if err == nil {
+			delete(pending, transfer.ID)
+			c.unprioritize(transfer.ID)
+		}
+		if err == errDownloadWindowClosed || err == errBudgetExceeded || time.Since(started) >= config.PollInterval {
+			return
+		}
+	}
+}
+
+// notifyTransferEvents logs changes of transfers and runs the
+// on_transfer_finished hook once per transfer.
+func (c *Cli) notifyTransferEvents(ctx context.Context, events <-chan poller.Event, config DownloadWatchConfig) {
+	for {
+		var event poller.Event
+		select {
+		case <-ctx.Done():
+			return
+		case event = <-events:
+		}
+
+		transfer := event.Transfer
+		switch event.Op {
+		case poller.Added:
+			if !c.isTorrentFinished(transfer.Status) {
+				fmt.Printf("Transfer %s added [%s]\n", transfer.Name, transfer.Status)
+			}
+		case poller.StatusChanged:
+			fmt.Printf("Transfer %s is %s, was %s\n", transfer.Name, transfer.Status, event.Previous.Status)
+		case poller.Finished:
+			if !c.hasBeenUploadedWhenStrict(config.Strict, transfer) || !c.markTransferFinished(transfer.ID) {
+				continue
 			}
-			time.Sleep(time.Duration(delay) * time.Minute)
+			fmt.Printf("Transfer %s finished\n", transfer.Name)
+			category := c.categoryFor(c.uploadLocation(transfer), config.Defaults)
+			c.runHook(c.hooksFor(category), hookTransferFinished, hookEnv{
+				ID:   transfer.ID,
+				Name: transfer.Name,
+				Hash: transfer.Hash,
+			})
+		case poller.Removed:
+			fmt.Printf("Transfer %s removed\n", transfer.Name)
 		}
-	}()
+	}
+}
+
+// downloadPending downloads a finished transfer unless it has been downloaded
+// already or is not meant to be downloaded. Outside of the download windows
+// errDownloadWindowClosed is returned.
+func (c *Cli) downloadPending(ctx context.Context, transfer premiumize.TorrentItem, config DownloadWatchConfig) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if c.isDownloaded(transfer.ID) || c.isDeletionScheduled(transfer.ID) || !c.hasBeenUploadedWhenStrict(config.Strict, transfer) {
+		return nil
+	}
+	if !c.isDownloadWindowOpen() {
+		return errDownloadWindowClosed
+	}
+
+	ctx, cancel := c.scheduledContext(ctx)
+	defer cancel()
 
-	<-done
+	if config.SyncFile {
+		c.createSyncFile(config.Directory)
+		defer c.deleteSyncFile(config.Directory)
+	}
+	return c.downloadCategorized(ctx, transfer, config)
+}
+
+// downloadCategorized downloads a finished transfer according to the rules of
+// the category its upload location belongs to.
+func (c *Cli) downloadCategorized(ctx context.Context, transfer premiumize.TorrentItem, config DownloadWatchConfig) error {
+	location := c.uploadLocation(transfer)
+	category := c.categoryFor(location, config.Defaults)
+	if category.Manual {
+		return nil
+	}
+
+	hooks := c.hooksFor(category)
+	transferDirectory := category.directory(config.Directory, location)
+	failed := func(err error) {
+		c.runHook(hooks, hookError, hookEnv{
+			ID:    transfer.ID,
+			Name:  transfer.Name,
+			Hash:  transfer.Hash,
+			Dir:   transferDirectory,
+			Error: err.Error(),
+		})
+	}
+
+	if remaining, limited := c.remainingBudget(); limited && remaining == 0 {
+		fmt.Printf("Deferring %s, the download budget is used up\n", transfer.Name)
+		return errBudgetExceeded
+	}
+
+	// Transfers which do not fit into the remaining budget are deferred as a
+	// whole instead of being cut off partway
+	limit := downloadLimit{budgeted: true}
+	download := func() (downloadResult, error) {
+		if category.AsZip {
+			return c.downloadTransferZip(ctx, transfer, transferDirectory, category.filter(), category.Flatten, category.Extract, limit)
+		}
+		return c.downloadTransfer(ctx, transfer, transferDirectory, category.filter(), category.Flatten, limit)
+	}
+
+	result, err := download()
+	if err == nil && config.Verify {
+		result, err = verifyDownload(transfer, transferDirectory, category.Flatten, result, download)
+	}
+	if err == nil {
+		err = checkDownloaded(result)
+	}
+	if err == errBudgetExceeded {
+		fmt.Printf("Deferring %s, it does not fit into the remaining download budget\n", transfer.Name)
+		return err
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Printf("Failed to download %s: %s\n", transfer.Name, err.Error())
+			failed(err)
+		}
+		return err
+	}
+
+	var extracted []string
+	if category.Extract {
+		extracted, err = extractArchives(result, category)
+		if err != nil {
+			failed(err)
+			return err
+		}
+	}
+
+	var files []string
+	for _, task := range result.Tasks {
+		if _, err := os.Stat(task.Destination); err == nil {
+			files = append(files, task.Destination)
+		}
+	}
+	files = append(files, extracted...)
+
+	if config.Manifest {
+		if err := writeManifest(result); err != nil {
+			fmt.Printf("Could not write manifest for %s: %s\n", transfer.Name, err.Error())
+		}
+	}
+
+	c.markDownloaded(transfer.ID)
+	c.runHook(hooks, hookDownloadComplete, hookEnv{
+		ID:    transfer.ID,
+		Name:  transfer.Name,
+		Hash:  transfer.Hash,
+		Dir:   transferDirectory,
+		Files: files,
+	})
+
+	if category.DeleteDownloaded {
+		c.scheduleDeletion(transfer, config.DeleteGracePeriod)
+	}
+	return nil
+}
+
+// verifyDownload checks a downloaded transfer against its archived torrent
+// file. Corrupt files are deleted and downloaded once more before giving up.
+// Transfers without an archived torrent file are not verified.
+func verifyDownload(transfer premiumize.TorrentItem, transferDirectory string, flatten bool, result downloadResult, download func() (downloadResult, error)) (downloadResult, error) {
+	for attempt := 0; ; attempt++ {
+		verified, err := verifyTransfer(transfer.Hash, transferDirectory, flatten)
+		if err != nil {
+			fmt.Printf("Skipping verification of %s: %s\n", transfer.Name, err.Error())
+			return result, nil
+		}
+		if len(verified.Corrupt) == 0 {
+			fmt.Printf("Verified %d files of %s\n", len(verified.Verified), transfer.Name)
+			return result, nil
+		}
+
+		for _, path := range verified.Corrupt {
+			fmt.Printf("%s is corrupt\n", path)
+		}
+		if attempt > 0 {
+			return result, fmt.Errorf("%d files of %s are still corrupt after downloading them again", len(verified.Corrupt), transfer.Name)
+		}
+
+		for _, path := range verified.Corrupt {
+			if err := os.Remove(path); err != nil {
+				return result, err
+			}
+		}
+		if result, err = download(); err != nil {
+			return result, err
+		}
+	}
 }
 
 func (c *Cli) mkdir(path string) {
@@ -209,14 +596,41 @@ func (c *Cli) hasBeenUploadedWhenStrict(strict bool, transfer premiumize.Torrent
 		return true
 	}
 
-	err := c.bolt.View(func(tx *bolt.Tx) error {
+	uploaded := false
+	c.view(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(torrentsBucket))
-		name := bucket.Get([]byte(transfer.ID))
-		if name == nil {
-			return fmt.Errorf("Not found")
-		}
+		uploaded = bucket != nil && bucket.Get([]byte(transfer.ID)) != nil
 		return nil
 	})
+	return uploaded
+}
 
-	return err == nil
+// storeUploadLocation remembers the upload subfolder a transfer has been
+// created from.
+func (c *Cli) storeUploadLocation(id string, location string) error {
+	return c.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(torrentsBucket))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), []byte(location))
+	})
+}
+
+// uploadLocation returns the upload subfolder a transfer was created from.
+func (c *Cli) uploadLocation(transfer premiumize.TorrentItem) string {
+	var location string
+	c.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(torrentsBucket))
+		if bucket == nil {
+			return nil
+		}
+		location = string(bucket.Get([]byte(transfer.ID)))
+		return nil
+	})
+	if !isUploadLocation(location) {
+		fmt.Printf("Ignoring upload location %s of %s\n", location, transfer.Name)
+		return ""
+	}
+	return location
 }
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractLocation(t *testing.T) {
+	tests := []struct {
+		basePath string
+		filePath string
+		want     string
+	}{
+		{"upload", "upload/a.torrent", ""},
+		{"upload", "upload/tv/a.torrent", "tv"},
+		{"upload", "upload/tv/shows/a.torrent", "tv/shows"},
+		{"/data/upload", "/data/upload/movies/a.magnet", "movies"},
+	}
+
+	for _, test := range tests {
+		got := extractLocation(filepath.FromSlash(test.basePath), filepath.FromSlash(test.filePath))
+		if got != test.want {
+			t.Errorf("extractLocation(%q, %q) = %q, want %q", test.basePath, test.filePath, got, test.want)
+		}
+	}
+}
+
+func TestLegacyLocation(t *testing.T) {
+	abs, err := filepath.Abs("upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		directory string
+		stored    string
+		want      string
+		ok        bool
+	}{
+		{"upload", "upload/tv", "tv", true},
+		{"upload/", "upload/tv/shows", "tv/shows", true},
+		{"upload", filepath.Join(abs, "movies"), "movies", true},
+		{"upload", "upload", "", true},
+		{"upload", "tv", "", false},
+		{"upload", "uploads/tv", "", false},
+		{"upload", "", "", false},
+	}
+
+	for _, test := range tests {
+		got, ok := legacyLocation(filepath.FromSlash(test.directory), filepath.FromSlash(test.stored))
+		if got != test.want || ok != test.ok {
+			t.Errorf("legacyLocation(%q, %q) = %q, %v, want %q, %v", test.directory, test.stored, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestIsUploadLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		want     bool
+	}{
+		{"", true},
+		{"tv", true},
+		{"tv/shows", true},
+		{"/data/upload/tv", false},
+		{"../tv", false},
+		{"tv/../..", false},
+		{"tv//shows", false},
+		{"./tv", false},
+	}
+
+	for _, test := range tests {
+		if got := isUploadLocation(test.location); got != test.want {
+			t.Errorf("isUploadLocation(%q) = %v, want %v", test.location, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pget/premiumize"
+	"strings"
+	"time"
+)
+
+// downloadTransferZip downloads a transfer as the zip archive premiumize
+// builds for it, which beats one request per file for transfers with many
+// small files. When unpacking, the files accepted by the filter are extracted
+// as a regular download would place them and the archive is removed. Without
+// unpacking the whole archive is kept, so the filter does not apply.
+func (c *Cli) downloadTransferZip(ctx context.Context, transfer premiumize.TorrentItem, targetDirectory string, filter fileFilter, flatten bool, unpack bool, limit downloadLimit) (downloadResult, error) {
+	torrent, err := c.premiumize.BrowseTorrent(transfer.Hash)
+	if err != nil {
+		fmt.Println(err.Error())
+		return downloadResult{}, err
+	}
+
+	if torrent.Zip == "" {
+		err := fmt.Errorf("No zip archive available for %s", transfer.Name)
+		fmt.Println(err.Error())
+		return downloadResult{}, err
+	}
+
+	zipTask := DownloadTask{
+		Destination: filepath.Join(targetDirectory, strings.Replace(transfer.Name, "/", "_", -1)+".zip"),
+		URL:         torrent.Zip,
+		Size:        uint64(torrent.Size),
+	}
+
+	folder := targetDirectory
+	if unpack {
+		folder = transferFolder(targetDirectory, torrent.Content, flatten)
+	}
+	result := downloadResult{
+		Transfer:     transfer,
+		Folder:       folder,
+		SharedFolder: folder == targetDirectory,
+		Tasks:        []DownloadTask{zipTask},
+		Started:      time.Now(),
+	}
+
+	marker, err := createInProgressMarker(result.Folder, transfer)
+	if err != nil {
+		fmt.Printf("Could not create in-progress marker: %s\n", err.Error())
+	} else {
+		defer removeMarker(marker)
+	}
+
+	if err := c.download(ctx, result.Tasks, limit); err != nil {
+		return result, err
+	}
+
+	// premiumize stores the files without compression, so a complete
+	// archive is at least as large as the transfer
+	stat, err := os.Stat(zipTask.Destination)
+	if err != nil {
+		return result, err
+	}
+	if stat.Size() < torrent.Size {
+		return result, fmt.Errorf("%s has %d bytes, expected at least %d", zipTask.Destination, stat.Size(), torrent.Size)
+	}
+
+	if !unpack {
+		result.Tasks[0].Size = uint64(stat.Size())
+	} else {
+		files, err := extractArchive(archive{Name: zipTask.Destination, Parts: []string{zipTask.Destination}}, targetDirectory, filter, flatten)
+		if err != nil {
+			fmt.Printf("Unable to unpack %s: %s\n", zipTask.Destination, err.Error())
+			return result, err
+		}
+		fmt.Printf("Unpacked %d files from %s\n", len(files), zipTask.Destination)
+
+		if err := os.Remove(zipTask.Destination); err != nil {
+			fmt.Printf("Could not delete %s: %s\n", zipTask.Destination, err.Error())
+		}
+
+		result.Tasks = nil
+		for _, file := range files {
+			stat, err := os.Stat(file)
+			if err != nil {
+				return result, err
+			}
+			result.Tasks = append(result.Tasks, DownloadTask{Destination: file, Size: uint64(stat.Size())})
+		}
+	}
+
+	result.Completed = time.Now()
+	return result, nil
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 	"gopkg.in/alecthomas/kingpin.v2"
 	"os"
+	"os/signal"
 	"pget/cli"
 	"pget/premiumize"
 	"sync"
+	"syscall"
 )
 
 func main() {
@@ -45,24 +49,175 @@ func main() {
 	downloadFlattenFlag := downloadCommand.Flag("flatten", "Ignore directories").Short('f').Bool()
 	downloadStopAfterFlag := downloadCommand.Flag("stop-after", "Stop download after x [43mb, 4gb]").Short('s').String()
 	downloadDirectoryFlag := downloadCommand.Flag("directory", "Directory to which the files should be downloaded").Short('d').Default(".").String()
+	downloadAsZipFlag := downloadCommand.Flag("as-zip", "Download the whole torrent as a single zip archive").Bool()
+	downloadUnpackFlag := downloadCommand.Flag("unpack", "Unpack the zip archive downloaded with --as-zip").Bool()
+	downloadDryRunFlag := downloadCommand.Flag("dry-run", "Only print the files that would be downloaded").Bool()
+	downloadJSONFlag := downloadCommand.Flag("json", "Print the dry run as JSON").Bool()
+
+	fetchCommand := application.Command("fetch", "Resolve hoster links through premiumize and download them")
+	fetchLinksArg := fetchCommand.Arg("link", "Hoster links").Required().Strings()
+	fetchVideoOnlyFlag := fetchCommand.Flag("video-only", "Only download video files (also ignores samples)").Short('v').Bool()
+	fetchFlattenFlag := fetchCommand.Flag("flatten", "Ignore directories").Short('f').Bool()
+	fetchStopAfterFlag := fetchCommand.Flag("stop-after", "Stop download after x [43mb, 4gb]").Short('s').String()
+	fetchDirectoryFlag := fetchCommand.Flag("directory", "Directory to which the files should be downloaded").Short('d').Default(".").String()
 
 	uploadCommand := application.Command("upload", "Upload a torrent file or magnet link")
 	uploadLink := uploadCommand.Arg("link", "Torrent file or magnet link").String()
+	uploadDryRunFlag := uploadCommand.Flag("dry-run", "Only print what would be uploaded").Bool()
+	uploadJSONFlag := uploadCommand.Flag("json", "Print the dry run as JSON").Bool()
+
+	checkCommand := application.Command("check", "Check if torrent files or magnet links are cached by premiumize")
+	checkLinksArg := checkCommand.Arg("link", "Torrent files or magnet links").Required().Strings()
+
+	cloudCommand := application.Command("cloud", "Manage the premiumize cloud folders")
+
+	cloudListCommand := cloudCommand.Command("ls", "List the content of a cloud folder")
+	cloudListPathArg := cloudListCommand.Arg("path", "Path of the cloud folder").String()
+
+	cloudTreeCommand := cloudCommand.Command("tree", "Print tree of a cloud folder")
+	cloudTreePathArg := cloudTreeCommand.Arg("path", "Path of the cloud folder").String()
+
+	cloudRemoveCommand := cloudCommand.Command("rm", "Delete a cloud file or folder")
+	cloudRemovePathArg := cloudRemoveCommand.Arg("path", "Path of the cloud file or folder").Required().String()
+
+	cloudRenameCommand := cloudCommand.Command("mv", "Rename a cloud file or folder")
+	cloudRenamePathArg := cloudRenameCommand.Arg("path", "Path of the cloud file or folder").Required().String()
+	cloudRenameNameArg := cloudRenameCommand.Arg("name", "New name").Required().String()
+
+	cloudGetCommand := cloudCommand.Command("get", "Download a cloud file or folder")
+	cloudGetPathArg := cloudGetCommand.Arg("path", "Path of the cloud file or folder").Required().String()
+	cloudGetVideoOnlyFlag := cloudGetCommand.Flag("video-only", "Only download video files (also ignores samples)").Short('v').Bool()
+	cloudGetFlattenFlag := cloudGetCommand.Flag("flatten", "Ignore directories").Short('f').Bool()
+	cloudGetStopAfterFlag := cloudGetCommand.Flag("stop-after", "Stop download after x [43mb, 4gb]").Short('s').String()
+	cloudGetDirectoryFlag := cloudGetCommand.Flag("directory", "Directory to which the files should be downloaded").Short('d').Default(".").String()
+
+	verifyCommand := application.Command("verify", "Verify downloaded files against the uploaded torrent file")
+	verifyNameArg := verifyCommand.Arg("name", "Name of the torrent").Required().String()
+	verifyFlattenFlag := verifyCommand.Flag("flatten", "Files have been downloaded without directories").Short('f').Bool()
+	verifyDirectoryFlag := verifyCommand.Flag("directory", "Directory to which the files have been downloaded").Short('d').Default(".").String()
+
+	undeleteCommand := application.Command("undelete", "Cancel the scheduled deletion of a downloaded torrent, lists scheduled deletions without a name")
+	undeleteNameArg := undeleteCommand.Arg("name", "Name or id of the torrent").String()
+
+	cleanupCommand := application.Command("cleanup", "Delete torrents matching the configured cleanup rules")
+	cleanupDryRunFlag := cleanupCommand.Flag("dry-run", "Only list the torrents that would be deleted").Bool()
+
+	queueCommand := application.Command("queue", "Manage torrents waiting for upload")
+	queueListCommand := queueCommand.Command("ls", "List queued torrents")
+	queueRemoveCommand := queueCommand.Command("rm", "Remove a torrent from the queue")
+	queueRemoveNameArg := queueRemoveCommand.Arg("name", "Name or id of the queued torrent").Required().String()
+	queueMoveCommand := queueCommand.Command("move", "Move a torrent to another position in the queue")
+	queueMoveNameArg := queueMoveCommand.Arg("name", "Name or id of the queued torrent").Required().String()
+	queueMovePositionArg := queueMoveCommand.Arg("position", "New position, starting at 1").Required().Int()
+
+	prioritizeCommand := application.Command("prioritize", "Download a finished torrent before all others in watch")
+	prioritizeNameArg := prioritizeCommand.Arg("name", "Name of the torrent").Required().String()
+
+	accountCommand := application.Command("account", "Show premium, space and fair use of the account")
+
+	statsCommand := application.Command("stats", "Show the account history recorded by watch")
+	statsDaysFlag := statsCommand.Flag("days", "Number of days to show").Default("30").Int()
 
 	watchCommand := application.Command("watch", "Watch for local or remote files to upload/download")
+	var downloadConfig cli.DownloadWatchConfig
 
 	watchUploadFlag := watchCommand.Flag("upload", "Directory to watch for new torrent files to upload").Default("-").String()
-	watchDeleteUploadedFlag := watchCommand.Flag("delete-uploaded", "Delete torrent file after upload").Bool()
+	watchOnlyCachedFlag := watchCommand.Flag("only-cached", "Only upload torrents that are already cached, others are moved to pending/").Bool()
+	watchRecheckFlag := watchCommand.Flag("recheck", "Delay between cache checks of pending torrents (in minutes, at least 1)").Default("30").Int()
+
+	watchMaxActiveFlag := watchCommand.Flag("max-active", "Keep torrents queued while this many are running on premiumize (0 = no limit)").Int()
+	watchCommand.Flag("download", "Directory to which torrents are downloaded").Default("-").StringVar(&downloadConfig.Directory)
+	watchCommand.Flag("strict", "Only download torrents that have also been uploaded by this tool").BoolVar(&downloadConfig.Strict)
+	watchCommand.Flag("video-only", "Only download video files (also ignores samples)").Short('v').BoolVar(&downloadConfig.Defaults.VideoOnly)
+	watchCommand.Flag("flatten", "Ignore directories").Short('f').BoolVar(&downloadConfig.Defaults.Flatten)
+	watchCommand.Flag("delete-downloaded", "Delete remote after downloaded").BoolVar(&downloadConfig.Defaults.DeleteDownloaded)
+	watchCommand.Flag("extract", "Extract downloaded zip and tar archives").BoolVar(&downloadConfig.Defaults.Extract)
+	watchCommand.Flag("delete-grace-period", "Time after download before a transfer is deleted with --delete-downloaded").Default("1h").DurationVar(&downloadConfig.DeleteGracePeriod)
+	watchCommand.Flag("sync-file", "Create .sync file in folder when downloading").BoolVar(&downloadConfig.SyncFile)
+	watchCommand.Flag("poll-interval", "Delay between checks for finished torrents while torrents change").Default("1m").DurationVar(&downloadConfig.PollInterval)
+	watchCommand.Flag("verify", "Verify downloaded files against the uploaded torrent file").BoolVar(&downloadConfig.Verify)
+	watchCommand.Flag("manifest", "Write a .pget-complete.json manifest for every downloaded transfer").BoolVar(&downloadConfig.Manifest)
+	watchShutdownTimeoutFlag := watchCommand.Flag("shutdown-timeout", "Time running downloads get to finish on shutdown").Default("30s").Duration()
+	watchDryRunFlag := watchCommand.Flag("dry-run", "Only print what a single cycle would upload, download and delete").Bool()
+	watchJSONFlag := watchCommand.Flag("json", "Print the dry run as JSON").Bool()
+
+	categories := make(map[string]cli.Category)
+	if err := unmarshalKey("categories", &categories); err != nil {
+		fmt.Printf("Invalid categories configuration: %s\n", err.Error())
+		return
+	}
+
+	hooks := make(map[string]cli.Hook)
+	if err := unmarshalKey("hooks", &hooks); err != nil {
+		fmt.Printf("Invalid hooks configuration: %s\n", err.Error())
+		return
+	}
+
+	var cleanup cli.CleanupConfig
+	if err := unmarshalKey("cleanup", &cleanup); err != nil {
+		fmt.Printf("Invalid cleanup configuration: %s\n", err.Error())
+		return
+	}
+
+	var stalled cli.StalledConfig
+	if err := unmarshalKey("stalled", &stalled); err != nil {
+		fmt.Printf("Invalid stalled configuration: %s\n", err.Error())
+		return
+	}
 
-	watchDownloadFlag := watchCommand.Flag("download", "Directory to which torrents are downloaded").Default("-").String()
-	watchStrictDownloadFlag := watchCommand.Flag("strict", "Only download torrents that have also been uploaded by this tool").Bool()
-	watchVideoOnlyFlag := watchCommand.Flag("video-only", "Only download video files (also ignores samples)").Short('v').Bool()
-	watchFlattenFlag := watchCommand.Flag("flatten", "Ignore directories").Short('f').Bool()
-	watchDeleteDownloadedFlag := watchCommand.Flag("delete-downloaded", "Delete remote after downloaded").Bool()
-	watchSyncFileFlag := watchCommand.Flag("sync-file", "Create .sync file in folder when downloading").Bool()
-	watchDownloadDelayFlag := watchCommand.Flag("delay", "Delay between download cycles (in minutes)").Int()
+	var account cli.AccountConfig
+	if err := unmarshalKey("account", &account); err != nil {
+		fmt.Printf("Invalid account configuration: %s\n", err.Error())
+		return
+	}
+
+	var schedule cli.ScheduleConfig
+	if err := unmarshalKey("schedule", &schedule); err != nil {
+		fmt.Printf("Invalid schedule configuration: %s\n", err.Error())
+		return
+	}
+
+	var budget cli.BudgetConfig
+	if err := unmarshalKey("budget", &budget); err != nil {
+		fmt.Printf("Invalid budget configuration: %s\n", err.Error())
+		return
+	}
+
+	var order cli.OrderConfig
+	if err := unmarshalKey("order", &order); err != nil {
+		fmt.Printf("Invalid order configuration: %s\n", err.Error())
+		return
+	}
 
 	cli := cli.New(premiumizeClient)
+	if err := cli.SetCategories(categories); err != nil {
+		fmt.Printf("Invalid categories configuration: %s\n", err.Error())
+		return
+	}
+	cli.SetHooks(hooks)
+	if err := cli.SetCleanup(cleanup); err != nil {
+		fmt.Printf("Invalid cleanup configuration: %s\n", err.Error())
+		return
+	}
+	if err := cli.SetStalled(stalled); err != nil {
+		fmt.Printf("Invalid stalled configuration: %s\n", err.Error())
+		return
+	}
+	cli.SetAccount(account)
+	if err := cli.SetSchedule(schedule); err != nil {
+		fmt.Printf("Invalid schedule configuration: %s\n", err.Error())
+		return
+	}
+	if err := cli.SetBudget(budget); err != nil {
+		fmt.Printf("Invalid budget configuration: %s\n", err.Error())
+		return
+	}
+	if err := cli.SetOrder(order); err != nil {
+		fmt.Printf("Invalid order configuration: %s\n", err.Error())
+		return
+	}
+	cli.SetWatcherPolling(viper.GetBool("watcher.polling"))
+	cli.SetWatcherQuietPeriod(viper.GetDuration("watcher.quiet_period"))
 
 	switch kingpin.MustParse(application.Parse(os.Args[1:])) {
 
@@ -76,45 +231,163 @@ func main() {
 
 	case downloadCommand.FullCommand():
 		premiumizeClient.SetDebug(*debugFlag)
-		cli.DownloadTorrent(*downloadNameArg, *downloadDirectoryFlag, *downloadVideoOnlyFlag, *downloadFlattenFlag, *downloadStopAfterFlag)
+		if *downloadDryRunFlag {
+			cli.PlanDownload(*downloadNameArg, *downloadDirectoryFlag, *downloadVideoOnlyFlag, *downloadFlattenFlag, *downloadStopAfterFlag, *downloadAsZipFlag, *downloadJSONFlag)
+			return
+		}
+		cli.DownloadTorrent(*downloadNameArg, *downloadDirectoryFlag, *downloadVideoOnlyFlag, *downloadFlattenFlag, *downloadStopAfterFlag, *downloadAsZipFlag, *downloadUnpackFlag)
+
+	case fetchCommand.FullCommand():
+		premiumizeClient.SetDebug(*debugFlag)
+		cli.Fetch(*fetchLinksArg, *fetchDirectoryFlag, *fetchVideoOnlyFlag, *fetchFlattenFlag, *fetchStopAfterFlag)
+
+	case verifyCommand.FullCommand():
+		premiumizeClient.SetDebug(*debugFlag)
+		cli.Verify(*verifyNameArg, *verifyDirectoryFlag, *verifyFlattenFlag)
+
+	case undeleteCommand.FullCommand():
+		premiumizeClient.SetDebug(*debugFlag)
+		cli.Undelete(*undeleteNameArg)
+
+	case cleanupCommand.FullCommand():
+		premiumizeClient.SetDebug(*debugFlag)
+		cli.Cleanup(*cleanupDryRunFlag)
+
+	case queueListCommand.FullCommand():
+		cli.QueueList()
+
+	case queueRemoveCommand.FullCommand():
+		cli.QueueRemove(*queueRemoveNameArg)
+
+	case queueMoveCommand.FullCommand():
+		cli.QueueMove(*queueMoveNameArg, *queueMovePositionArg)
+
+	case prioritizeCommand.FullCommand():
+		premiumizeClient.SetDebug(*debugFlag)
+		cli.Prioritize(*prioritizeNameArg)
+
+	case accountCommand.FullCommand():
+		premiumizeClient.SetDebug(*debugFlag)
+		cli.Account()
+
+	case statsCommand.FullCommand():
+		cli.Stats(*statsDaysFlag)
 
 	case uploadCommand.FullCommand():
 		premiumizeClient.SetDebug(*debugFlag)
+		if *uploadDryRunFlag {
+			cli.PlanUpload(*uploadLink, *uploadJSONFlag)
+			return
+		}
 		cli.Upload(*uploadLink)
 
+	case checkCommand.FullCommand():
+		premiumizeClient.SetDebug(*debugFlag)
+		cli.Check(*checkLinksArg)
+
+	case cloudListCommand.FullCommand():
+		premiumizeClient.SetDebug(*debugFlag)
+		cli.CloudList(*cloudListPathArg)
+
+	case cloudTreeCommand.FullCommand():
+		premiumizeClient.SetDebug(*debugFlag)
+		cli.CloudTree(*cloudTreePathArg)
+
+	case cloudRemoveCommand.FullCommand():
+		premiumizeClient.SetDebug(*debugFlag)
+		cli.CloudRemove(*cloudRemovePathArg)
+
+	case cloudRenameCommand.FullCommand():
+		premiumizeClient.SetDebug(*debugFlag)
+		cli.CloudRename(*cloudRenamePathArg, *cloudRenameNameArg)
+
+	case cloudGetCommand.FullCommand():
+		premiumizeClient.SetDebug(*debugFlag)
+		cli.CloudGet(*cloudGetPathArg, *cloudGetDirectoryFlag, *cloudGetVideoOnlyFlag, *cloudGetFlattenFlag, *cloudGetStopAfterFlag)
+
 	case watchCommand.FullCommand():
 		premiumizeClient.SetDebug(*debugFlag)
 
+		if *watchDryRunFlag {
+			uploadDirectory := *watchUploadFlag
+			if uploadDirectory == "-" {
+				uploadDirectory = ""
+			}
+			if downloadConfig.Directory == "-" {
+				downloadConfig.Directory = ""
+			}
+			cli.PlanWatch(uploadDirectory, *watchOnlyCachedFlag, downloadConfig, *watchJSONFlag)
+			return
+		}
+
+		cli.SetShutdownTimeout(*watchShutdownTimeoutFlag)
+		ctx := handleSignals()
+
+		if *watchUploadFlag != "-" {
+			cli.MigrateUploadLocations(*watchUploadFlag)
+		}
+
 		var wg sync.WaitGroup
 
+		if *watchUploadFlag != "-" || downloadConfig.Directory != "-" {
+			wg.Add(1)
+			go func() {
+				cli.WatchAccount(ctx)
+				wg.Done()
+			}()
+		}
+
 		if *watchUploadFlag != "-" {
 			wg.Add(1)
 			go func() {
-				cli.WatchAndUpload(*watchUploadFlag, *watchStrictDownloadFlag, *watchDeleteUploadedFlag)
+				cli.WatchAndUpload(ctx, *watchUploadFlag, downloadConfig.Strict, *watchOnlyCachedFlag, *watchRecheckFlag, *watchMaxActiveFlag)
 				wg.Done()
 			}()
 		}
 
-		if *watchDownloadFlag != "-" {
+		if downloadConfig.Directory != "-" {
 			wg.Add(1)
 			go func() {
-				if *watchDownloadDelayFlag < 10 {
-					*watchDownloadDelayFlag = 10
-				}
-
-				cli.WatchAndDownload(
-					*watchDownloadFlag,
-					*watchVideoOnlyFlag,
-					*watchFlattenFlag,
-					*watchStrictDownloadFlag,
-					*watchDeleteDownloadedFlag,
-					*watchSyncFileFlag,
-					*watchDownloadDelayFlag,
-				)
+				cli.WatchAndDownload(ctx, downloadConfig)
 				wg.Done()
 			}()
 		}
 
 		wg.Wait()
+		cli.Close()
+	}
+}
+
+// unmarshalKey is viper.UnmarshalKey, but also decodes durations given as
+// strings like "30m".
+func unmarshalKey(key string, value interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+		Result:     value,
+	})
+	if err != nil {
+		return err
 	}
+	return decoder.Decode(viper.Get(key))
+}
+
+// handleSignals returns a context which is done on the first SIGINT or
+// SIGTERM. A second signal exits immediately.
+func handleSignals() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		fmt.Println("Shutting down, waiting for running downloads. Press Ctrl-C again to exit immediately.")
+		cancel()
+
+		<-signals
+		fmt.Println("Exiting immediately")
+		os.Exit(1)
+	}()
+
+	return ctx
 }
@@ -0,0 +1,308 @@
+package poller
+
+import (
+	"context"
+	"pget/premiumize"
+	"sync"
+	"time"
+)
+
+const defaultMinInterval = time.Minute
+const defaultMaxInterval = 10 * time.Minute
+const finishedStatus = "finished"
+
+// Op describes what happened to a transfer between two polls.
+type Op int
+
+const (
+	// Added is emitted for transfers which were not part of the previous
+	// poll, including all transfers of the first poll.
+	Added Op = iota + 1
+	// Progress is emitted when the progress of a transfer changed.
+	Progress
+	// StatusChanged is emitted when the status of a transfer changed.
+	StatusChanged
+	// Finished is emitted when a transfer is finished, also when it is
+	// already finished when it is added.
+	Finished
+	// Removed is emitted when a transfer is no longer listed.
+	Removed
+	// Polled is emitted after the events of every poll and carries all
+	// transfers that are listed.
+	Polled
+)
+
+func (op Op) String() string {
+	switch op {
+	case Added:
+		return "added"
+	case Progress:
+		return "progress"
+	case StatusChanged:
+		return "status changed"
+	case Finished:
+		return "finished"
+	case Removed:
+		return "removed"
+	case Polled:
+		return "polled"
+	}
+	return "unknown"
+}
+
+// Event describes a change of a transfer. Previous holds the transfer as of the
+// previous poll, it is empty for added transfers. Transfers is only set for
+// Polled.
+type Event struct {
+	Op        Op
+	Transfer  premiumize.TorrentItem
+	Previous  premiumize.TorrentItem
+	Transfers []premiumize.TorrentItem
+}
+
+type TransferPoller struct {
+	config       TransferPollerConfig
+	snapshot     map[string]premiumize.TorrentItem
+	interval     time.Duration
+	watcher      []chan<- Event
+	subscribers  []*subscriber
+	errorWatcher []chan<- error
+	watcherMutex sync.RWMutex
+	running      bool
+	cancel       context.CancelFunc
+	done         chan struct{}
+	runningMutex sync.Mutex
+}
+
+type TransferPollerConfig struct {
+	// List returns the current transfers, usually ListTorrents of the
+	// premiumize client.
+	List func() (premiumize.TorrentList, error)
+	// MinInterval is the poll interval while transfers change. Without
+	// changes, the interval doubles up to MaxInterval.
+	MinInterval time.Duration
+	MaxInterval time.Duration
+}
+
+func New(config TransferPollerConfig) *TransferPoller {
+	if config.MinInterval == 0 {
+		config.MinInterval = defaultMinInterval
+	}
+	if config.MaxInterval == 0 {
+		config.MaxInterval = defaultMaxInterval
+	}
+	if config.MaxInterval < config.MinInterval {
+		config.MaxInterval = config.MinInterval
+	}
+
+	return &TransferPoller{
+		config:   config,
+		interval: config.MinInterval,
+	}
+}
+
+// AddWatcher registers a channel for events. Watchers have to be added before
+// Run.
+func (p *TransferPoller) AddWatcher(watcher chan<- Event) {
+	p.watcherMutex.Lock()
+	p.watcher = append(p.watcher, watcher)
+	p.watcherMutex.Unlock()
+}
+
+// AddErrorWatcher registers a channel for failed polls. Errors are dropped if
+// nobody listens.
+func (p *TransferPoller) AddErrorWatcher(watcher chan<- error) {
+	p.watcherMutex.Lock()
+	p.errorWatcher = append(p.errorWatcher, watcher)
+	p.watcherMutex.Unlock()
+}
+
+// Run polls right away and then in the background until the context is done
+// or Stop is called. Events are queued for every watcher, so a busy watcher
+// delays neither the next poll nor the other watchers.
+func (p *TransferPoller) Run(ctx context.Context) {
+	p.runningMutex.Lock()
+	defer p.runningMutex.Unlock()
+
+	if p.running {
+		return
+	}
+	p.running = true
+
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.done = make(chan struct{})
+
+	p.watcherMutex.Lock()
+	p.subscribers = nil
+	for _, watcher := range p.watcher {
+		subscriber := &subscriber{watcher: watcher, events: make(chan Event)}
+		p.subscribers = append(p.subscribers, subscriber)
+		go subscriber.run(ctx)
+	}
+	p.watcherMutex.Unlock()
+
+	go func() {
+		defer close(p.done)
+
+		for {
+			p.poll(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(p.interval):
+			}
+		}
+	}()
+}
+
+// Stop ends polling and waits until no more events are emitted.
+func (p *TransferPoller) Stop() {
+	p.runningMutex.Lock()
+	defer p.runningMutex.Unlock()
+
+	if !p.running {
+		return
+	}
+
+	p.cancel()
+	<-p.done
+	p.running = false
+}
+
+func (p *TransferPoller) poll(ctx context.Context) {
+	list, err := p.config.List()
+	if err != nil {
+		p.emitError(ctx, err)
+		p.backOff()
+		return
+	}
+
+	events := diff(p.snapshot, list.Transfers)
+	p.snapshot = make(map[string]premiumize.TorrentItem)
+	for _, transfer := range list.Transfers {
+		p.snapshot[transfer.ID] = transfer
+	}
+
+	if len(events) > 0 {
+		p.interval = p.config.MinInterval
+	} else {
+		p.backOff()
+	}
+
+	for _, event := range events {
+		p.emit(ctx, event)
+	}
+	p.emit(ctx, Event{Op: Polled, Transfers: list.Transfers})
+}
+
+func (p *TransferPoller) backOff() {
+	p.interval *= 2
+	if p.interval > p.config.MaxInterval {
+		p.interval = p.config.MaxInterval
+	}
+}
+
+// diff returns the events leading from the previous snapshot to the current
+// transfers.
+func diff(previous map[string]premiumize.TorrentItem, transfers []premiumize.TorrentItem) []Event {
+	var events []Event
+	listed := make(map[string]bool)
+	for _, transfer := range transfers {
+		listed[transfer.ID] = true
+
+		before, ok := previous[transfer.ID]
+		if !ok {
+			events = append(events, Event{Op: Added, Transfer: transfer})
+			if transfer.Status == finishedStatus {
+				events = append(events, Event{Op: Finished, Transfer: transfer})
+			}
+			continue
+		}
+
+		if transfer.Progress != before.Progress {
+			events = append(events, Event{Op: Progress, Transfer: transfer, Previous: before})
+		}
+		if transfer.Status != before.Status {
+			events = append(events, Event{Op: StatusChanged, Transfer: transfer, Previous: before})
+			if transfer.Status == finishedStatus {
+				events = append(events, Event{Op: Finished, Transfer: transfer, Previous: before})
+			}
+		}
+	}
+
+	for id, before := range previous {
+		if !listed[id] {
+			events = append(events, Event{Op: Removed, Transfer: before, Previous: before})
+		}
+	}
+	return events
+}
+
+func (p *TransferPoller) emit(ctx context.Context, event Event) {
+	p.watcherMutex.RLock()
+	defer p.watcherMutex.RUnlock()
+
+	for _, subscriber := range p.subscribers {
+		select {
+		case subscriber.events <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// subscriber queues the events of a watcher until the watcher takes them. Only
+// the latest Polled event is kept, as it carries all transfers anyway.
+type subscriber struct {
+	watcher chan<- Event
+	events  chan Event
+}
+
+func (s *subscriber) run(ctx context.Context) {
+	var queue []Event
+	for {
+		var watcher chan<- Event
+		var next Event
+		if len(queue) > 0 {
+			watcher = s.watcher
+			next = queue[0]
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-s.events:
+			if event.Op == Polled {
+				queue = withoutPolled(queue)
+			}
+			queue = append(queue, event)
+		case watcher <- next:
+			queue = queue[1:]
+		}
+	}
+}
+
+func withoutPolled(events []Event) []Event {
+	var result []Event
+	for _, event := range events {
+		if event.Op != Polled {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+func (p *TransferPoller) emitError(ctx context.Context, err error) {
+	p.watcherMutex.RLock()
+	defer p.watcherMutex.RUnlock()
+
+	for _, watcher := range p.errorWatcher {
+		select {
+		case watcher <- err:
+		case <-ctx.Done():
+			return
+		default:
+		}
+	}
+}
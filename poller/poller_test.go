@@ -0,0 +1,265 @@
+package poller
+
+import (
+	"context"
+	"fmt"
+	"pget/premiumize"
+	"sort"
+	"testing"
+	"time"
+)
+
+func transfer(id string, status string, progress float64) premiumize.TorrentItem {
+	return premiumize.TorrentItem{ID: id, Name: "name " + id, Status: status, Progress: progress}
+}
+
+func snapshot(transfers ...premiumize.TorrentItem) map[string]premiumize.TorrentItem {
+	result := make(map[string]premiumize.TorrentItem)
+	for _, transfer := range transfers {
+		result[transfer.ID] = transfer
+	}
+	return result
+}
+
+// describe formats events as "op id" for comparison.
+func describe(events []Event) []string {
+	var result []string
+	for _, event := range events {
+		result = append(result, fmt.Sprintf("%s %s", event.Op, event.Transfer.ID))
+	}
+	return result
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		description string
+		previous    map[string]premiumize.TorrentItem
+		transfers   []premiumize.TorrentItem
+		want        []string
+	}{
+		{
+			description: "first poll",
+			transfers:   []premiumize.TorrentItem{transfer("a", "running", 0.5), transfer("b", "finished", 1)},
+			want:        []string{"added a", "added b", "finished b"},
+		},
+		{
+			description: "unchanged",
+			previous:    snapshot(transfer("a", "running", 0.5)),
+			transfers:   []premiumize.TorrentItem{transfer("a", "running", 0.5)},
+		},
+		{
+			description: "progress",
+			previous:    snapshot(transfer("a", "running", 0.5)),
+			transfers:   []premiumize.TorrentItem{transfer("a", "running", 0.75)},
+			want:        []string{"progress a"},
+		},
+		{
+			description: "status",
+			previous:    snapshot(transfer("a", "queued", 0)),
+			transfers:   []premiumize.TorrentItem{transfer("a", "running", 0)},
+			want:        []string{"status changed a"},
+		},
+		{
+			description: "finished",
+			previous:    snapshot(transfer("a", "running", 0.9)),
+			transfers:   []premiumize.TorrentItem{transfer("a", "finished", 1)},
+			want:        []string{"progress a", "status changed a", "finished a"},
+		},
+		{
+			description: "finished without progress",
+			previous:    snapshot(transfer("a", "seeding", 1)),
+			transfers:   []premiumize.TorrentItem{transfer("a", "finished", 1)},
+			want:        []string{"status changed a", "finished a"},
+		},
+		{
+			description: "removed",
+			previous:    snapshot(transfer("a", "running", 0.5), transfer("b", "finished", 1)),
+			transfers:   []premiumize.TorrentItem{transfer("a", "running", 0.5)},
+			want:        []string{"removed b"},
+		},
+		{
+			description: "replaced",
+			previous:    snapshot(transfer("a", "finished", 1)),
+			transfers:   []premiumize.TorrentItem{transfer("b", "queued", 0)},
+			want:        []string{"added b", "removed a"},
+		},
+	}
+
+	for _, test := range tests {
+		events := diff(test.previous, test.transfers)
+		got := describe(events)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: events %v, want %v", test.description, got, test.want)
+		}
+
+		for _, event := range events {
+			before, existed := test.previous[event.Transfer.ID]
+			if event.Op != Added && event.Op != Removed && existed && event.Previous != before {
+				t.Errorf("%s: %s has previous %+v, want %+v", test.description, event.Op, event.Previous, before)
+			}
+		}
+	}
+}
+
+func TestDiffRemovesEveryUnlistedTransfer(t *testing.T) {
+	events := diff(snapshot(transfer("a", "running", 0), transfer("b", "running", 0), transfer("c", "running", 0)), nil)
+	got := describe(events)
+	sort.Strings(got)
+	if want := []string{"removed a", "removed b", "removed c"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("events %v, want %v", got, want)
+	}
+}
+
+func TestPollInterval(t *testing.T) {
+	const min = time.Minute
+	const max = 5 * time.Minute
+
+	changing := premiumize.TorrentList{Transfers: []premiumize.TorrentItem{transfer("a", "running", 0.5)}}
+	tests := []struct {
+		description string
+		lists       []premiumize.TorrentList
+		errors      []error
+		want        time.Duration
+	}{
+		{description: "first poll adds transfers", lists: []premiumize.TorrentList{changing}, want: min},
+		{description: "first poll without transfers", lists: []premiumize.TorrentList{{}}, want: 2 * min},
+		{description: "unchanged doubles", lists: []premiumize.TorrentList{changing, changing, changing}, want: 4 * min},
+		{description: "capped at max", lists: []premiumize.TorrentList{changing, changing, changing, changing, changing}, want: max},
+		{description: "changes reset", lists: []premiumize.TorrentList{changing, changing, changing, {}}, want: min},
+		{description: "errors back off", lists: []premiumize.TorrentList{changing, {}}, errors: []error{nil, fmt.Errorf("offline")}, want: 2 * min},
+	}
+
+	for _, test := range tests {
+		call := 0
+		p := New(TransferPollerConfig{
+			List: func() (premiumize.TorrentList, error) {
+				list := test.lists[call]
+				var err error
+				if call < len(test.errors) {
+					err = test.errors[call]
+				}
+				call++
+				return list, err
+			},
+			MinInterval: min,
+			MaxInterval: max,
+		})
+
+		for range test.lists {
+			p.poll(context.Background())
+		}
+		if p.interval != test.want {
+			t.Errorf("%s: interval %s, want %s", test.description, p.interval, test.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	// The second poll waits until the events of the first one are received.
+	release := make(chan struct{})
+	call := 0
+	p := New(TransferPollerConfig{
+		List: func() (premiumize.TorrentList, error) {
+			call++
+			if call == 1 {
+				return premiumize.TorrentList{Transfers: []premiumize.TorrentItem{transfer("a", "running", 0.5)}}, nil
+			}
+			<-release
+			return premiumize.TorrentList{Transfers: []premiumize.TorrentItem{transfer("a", "finished", 1)}}, nil
+		},
+		MinInterval: time.Millisecond,
+		MaxInterval: time.Millisecond,
+	})
+
+	events := make(chan Event)
+	p.AddWatcher(events)
+	p.Run(context.Background())
+	defer p.Stop()
+
+	receive := func(want ...string) []Event {
+		var got []Event
+		for range want {
+			select {
+			case event := <-events:
+				got = append(got, event)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("received %v, want %v", describe(got), want)
+			}
+		}
+		if fmt.Sprint(describe(got)) != fmt.Sprint(want) {
+			t.Fatalf("received %v, want %v", describe(got), want)
+		}
+		return got
+	}
+
+	receive("added a", "polled ")
+	close(release)
+	got := receive("progress a", "status changed a", "finished a", "polled ")
+	if polled := got[len(got)-1]; len(polled.Transfers) != 1 || polled.Transfers[0].Status != "finished" {
+		t.Errorf("polled %+v, want the finished transfer", polled.Transfers)
+	}
+}
+
+func TestWithoutPolled(t *testing.T) {
+	tests := []struct {
+		events []Event
+		want   []string
+	}{
+		{},
+		{events: []Event{{Op: Polled}}},
+		{events: []Event{{Op: Added, Transfer: transfer("a", "", 0)}}, want: []string{"added a"}},
+		{
+			events: []Event{{Op: Added, Transfer: transfer("a", "", 0)}, {Op: Polled}, {Op: Progress, Transfer: transfer("a", "", 0)}, {Op: Polled}},
+			want:   []string{"added a", "progress a"},
+		},
+	}
+
+	for _, test := range tests {
+		if got := describe(withoutPolled(test.events)); fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("withoutPolled(%v) = %v, want %v", describe(test.events), got, test.want)
+		}
+	}
+}
+
+func TestSubscriberCoalescesPolled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watcher := make(chan Event)
+	s := &subscriber{watcher: watcher, events: make(chan Event)}
+	go s.run(ctx)
+
+	// Nobody receives while the events are queued, like a busy watcher.
+	first := Event{Op: Polled, Transfers: []premiumize.TorrentItem{transfer("a", "running", 0.5)}}
+	second := Event{Op: Polled, Transfers: []premiumize.TorrentItem{transfer("a", "running", 0.75)}}
+	for _, event := range []Event{{Op: Added, Transfer: transfer("a", "running", 0.5)}, first, {Op: Progress, Transfer: transfer("a", "running", 0.75)}, second} {
+		select {
+		case s.events <- event:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("subscriber blocked on %s", event.Op)
+		}
+	}
+
+	var got []Event
+	for len(got) < 3 {
+		select {
+		case event := <-watcher:
+			got = append(got, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %v", describe(got))
+		}
+	}
+
+	if want := []string{"added a", "progress a", "polled "}; fmt.Sprint(describe(got)) != fmt.Sprint(want) {
+		t.Errorf("received %v, want %v", describe(got), want)
+	}
+	if progress := got[2].Transfers[0].Progress; progress != 0.75 {
+		t.Errorf("polled progress %v, want the latest poll", progress)
+	}
+
+	select {
+	case event := <-watcher:
+		t.Errorf("unexpected %s", event.Op)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
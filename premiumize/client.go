@@ -18,6 +18,9 @@ const listTorrentsURL = "https://www.premiumize.me/api/transfer/list"
 const browseTorrentURL = "https://www.premiumize.me/api/torrent/browse"
 const startTorrentURL = "https://www.premiumize.me/api/transfer/create"
 const deleteTorrentURL = "https://www.premiumize.me/api/transfer/delete"
+const directDownloadURL = "https://www.premiumize.me/api/transfer/directdl"
+const checkHashesURL = "https://www.premiumize.me/api/torrent/checkhashes"
+const accountInfoURL = "https://www.premiumize.me/api/account/info"
 const premiumizeErrorStatus = "error"
 
 type Client struct {
@@ -151,10 +154,16 @@ func (c *Client) DeleteTorrent(id string) (DeleteResponse, error) {
 		return DeleteResponse{}, err
 	}
 
+	if response.Status == premiumizeErrorStatus {
+		return DeleteResponse{}, fmt.Errorf("%s", response.Message)
+	}
+
 	return response, nil
 }
 
-func (c *Client) UploadTorrentFile(path string) (UploadResponse, error) {
+// UploadTorrentFile starts a transfer for a torrent file. The result is placed
+// in the cloud folder with the given id, or the root folder if empty.
+func (c *Client) UploadTorrentFile(path string, folderID string) (UploadResponse, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return UploadResponse{}, err
@@ -175,6 +184,9 @@ func (c *Client) UploadTorrentFile(path string) (UploadResponse, error) {
 	writer.WriteField("customer_id", c.customerID)
 	writer.WriteField("pin", c.pin)
 	writer.WriteField("type", "torrent")
+	if folderID != "" {
+		writer.WriteField("folder_id", folderID)
+	}
 
 	req, err := http.NewRequest("POST", startTorrentURL, body)
 	if err != nil {
@@ -210,12 +222,17 @@ func (c *Client) UploadTorrentFile(path string) (UploadResponse, error) {
 	return response, nil
 }
 
-func (c *Client) UploadMagnetLink(link string) (UploadResponse, error) {
+// UploadMagnetLink starts a transfer for a magnet link. The result is placed
+// in the cloud folder with the given id, or the root folder if empty.
+func (c *Client) UploadMagnetLink(link string, folderID string) (UploadResponse, error) {
 	form := url.Values{}
 	form.Set("customer_id", c.customerID)
 	form.Set("pin", c.pin)
 	form.Set("type", "torrent")
 	form.Set("src", link)
+	if folderID != "" {
+		form.Set("folder_id", folderID)
+	}
 
 	resp, err := c.http.PostForm(startTorrentURL, form)
 	if err != nil {
@@ -244,3 +261,94 @@ func (c *Client) UploadMagnetLink(link string) (UploadResponse, error) {
 
 	return response, nil
 }
+
+func (c *Client) DirectDownload(link string) (DirectDownload, error) {
+	form := c.newForm()
+	form.Set("src", link)
+
+	content, err := c.post(directDownloadURL, form, "DIRECT DOWNLOAD")
+	if err != nil {
+		return DirectDownload{}, err
+	}
+
+	response := DirectDownload{}
+	err = json.Unmarshal(content, &response)
+	if err != nil {
+		return DirectDownload{}, err
+	}
+
+	if response.Status == premiumizeErrorStatus {
+		return DirectDownload{}, fmt.Errorf("%s", response.Message)
+	}
+
+	return response, nil
+}
+
+func (c *Client) CheckHashes(hashes []string) (HashCheck, error) {
+	form := c.newForm()
+	for _, hash := range hashes {
+		form.Add("hashes[]", hash)
+	}
+
+	content, err := c.post(checkHashesURL, form, "CHECK HASHES")
+	if err != nil {
+		return HashCheck{}, err
+	}
+
+	response := HashCheck{}
+	err = json.Unmarshal(content, &response)
+	if err != nil {
+		return HashCheck{}, err
+	}
+
+	if response.Status == premiumizeErrorStatus {
+		return HashCheck{}, fmt.Errorf("%s", response.Message)
+	}
+
+	return response, nil
+}
+
+func (c *Client) AccountInfo() (AccountInfo, error) {
+	content, err := c.post(accountInfoURL, c.newForm(), "ACCOUNT INFO")
+	if err != nil {
+		return AccountInfo{}, err
+	}
+
+	response := AccountInfo{}
+	err = json.Unmarshal(content, &response)
+	if err != nil {
+		return AccountInfo{}, err
+	}
+
+	if response.Status == premiumizeErrorStatus {
+		return AccountInfo{}, fmt.Errorf("%s", response.Message)
+	}
+
+	return response, nil
+}
+
+func (c *Client) newForm() url.Values {
+	form := url.Values{}
+	form.Set("customer_id", c.customerID)
+	form.Set("pin", c.pin)
+	return form
+}
+
+func (c *Client) post(apiURL string, form url.Values, name string) ([]byte, error) {
+	resp, err := c.http.PostForm(apiURL, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.debug {
+		fmt.Printf("DEBUG ENABLED. DUMPING %s RESPONSE:\n%s\n", name, content)
+	}
+
+	return content, nil
+}
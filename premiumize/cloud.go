@@ -0,0 +1,146 @@
+package premiumize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const listFolderURL = "https://www.premiumize.me/api/folder/list"
+const createFolderURL = "https://www.premiumize.me/api/folder/create"
+const renameFolderURL = "https://www.premiumize.me/api/folder/rename"
+const deleteFolderURL = "https://www.premiumize.me/api/folder/delete"
+const renameItemURL = "https://www.premiumize.me/api/item/rename"
+const deleteItemURL = "https://www.premiumize.me/api/item/delete"
+
+const CloudFolderType = "folder"
+const CloudFileType = "file"
+
+// ListFolder lists the content of a cloud folder. An empty id lists the root folder.
+func (c *Client) ListFolder(id string) (CloudList, error) {
+	form := c.newForm()
+	if id != "" {
+		form.Set("id", id)
+	}
+
+	content, err := c.post(listFolderURL, form, "FOLDER LIST")
+	if err != nil {
+		return CloudList{}, err
+	}
+
+	list := CloudList{}
+	err = json.Unmarshal(content, &list)
+	if err != nil {
+		return CloudList{}, err
+	}
+
+	if list.Status == premiumizeErrorStatus {
+		return CloudList{}, fmt.Errorf("%s", list.Message)
+	}
+
+	return list, nil
+}
+
+// BrowseFolder walks a cloud folder recursively and returns its files in the
+// same structure as the content of a torrent. Paths start with the folder name.
+func (c *Client) BrowseFolder(folder CloudItem) (map[string]TorrentContent, error) {
+	list, err := c.ListFolder(folder.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	content := make(map[string]TorrentContent)
+	for _, item := range list.Content {
+		itemPath := path.Join(folder.Name, item.Name)
+
+		if item.Type == CloudFolderType {
+			children, err := c.BrowseFolder(CloudItem{ID: item.ID, Name: itemPath})
+			if err != nil {
+				return nil, err
+			}
+			content[item.Name] = TorrentContent{
+				Type:     "dir",
+				Name:     item.Name,
+				Path:     itemPath,
+				Children: children,
+			}
+		} else {
+			content[item.Name] = CloudFileContent(item, itemPath)
+		}
+	}
+	return content, nil
+}
+
+// CloudFileContent converts a cloud file into torrent content located at the given path.
+func CloudFileContent(item CloudItem, itemPath string) TorrentContent {
+	size, _ := item.Size.Int64()
+	return TorrentContent{
+		Type: "file",
+		Name: item.Name,
+		Size: size,
+		Ext:  strings.TrimPrefix(strings.ToLower(path.Ext(item.Name)), "."),
+		URL:  item.Link,
+		Path: itemPath,
+	}
+}
+
+func (c *Client) CreateFolder(name string, parentID string) (CloudResponse, error) {
+	form := c.newForm()
+	form.Set("name", name)
+	if parentID != "" {
+		form.Set("parent_id", parentID)
+	}
+
+	return c.cloudRequest(createFolderURL, form, "FOLDER CREATE")
+}
+
+func (c *Client) RenameFolder(id string, name string) (CloudResponse, error) {
+	form := c.newForm()
+	form.Set("id", id)
+	form.Set("name", name)
+
+	return c.cloudRequest(renameFolderURL, form, "FOLDER RENAME")
+}
+
+func (c *Client) DeleteFolder(id string) (CloudResponse, error) {
+	form := c.newForm()
+	form.Set("id", id)
+
+	return c.cloudRequest(deleteFolderURL, form, "FOLDER DELETE")
+}
+
+func (c *Client) RenameItem(id string, name string) (CloudResponse, error) {
+	form := c.newForm()
+	form.Set("id", id)
+	form.Set("name", name)
+
+	return c.cloudRequest(renameItemURL, form, "ITEM RENAME")
+}
+
+func (c *Client) DeleteItem(id string) (CloudResponse, error) {
+	form := c.newForm()
+	form.Set("id", id)
+
+	return c.cloudRequest(deleteItemURL, form, "ITEM DELETE")
+}
+
+func (c *Client) cloudRequest(apiURL string, form url.Values, name string) (CloudResponse, error) {
+	content, err := c.post(apiURL, form, name)
+	if err != nil {
+		return CloudResponse{}, err
+	}
+
+	response := CloudResponse{}
+	err = json.Unmarshal(content, &response)
+	if err != nil {
+		return CloudResponse{}, err
+	}
+
+	if response.Status == premiumizeErrorStatus {
+		return CloudResponse{}, fmt.Errorf("%s", response.Message)
+	}
+
+	return response, nil
+}
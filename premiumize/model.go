@@ -1,18 +1,30 @@
 package premiumize
 
+import "encoding/json"
+
 type CloudList struct {
-	Status  string      `json:"status"`
-	Content []CloudItem `json:"content"`
-	Message string      `json:"message"`
+	Status   string      `json:"status"`
+	Content  []CloudItem `json:"content"`
+	Message  string      `json:"message"`
+	Name     string      `json:"name"`
+	ParentID string      `json:"parent_id"`
+	FolderID string      `json:"folder_id"`
 }
 
 type CloudItem struct {
-	ID        string `json:"id"`
-	Hash      string `json:"hash"`
-	Size      string `json:"size"`
-	Name      string `json:"name"`
-	CreatedAt string `json:"created_at"`
-	Type      string `json:"type"`
+	ID        string      `json:"id"`
+	Hash      string      `json:"hash"`
+	Size      json.Number `json:"size"`
+	Name      string      `json:"name"`
+	CreatedAt json.Number `json:"created_at"`
+	Type      string      `json:"type"`
+	Link      string      `json:"link"`
+}
+
+type CloudResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	ID      string `json:"id"`
 }
 
 type TorrentList struct {
@@ -67,5 +79,67 @@ type UploadResponse struct {
 }
 
 type DeleteResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+type DirectDownload struct {
+	Status   string                  `json:"status"`
+	Message  string                  `json:"message"`
+	Location string                  `json:"location"`
+	Filename string                  `json:"filename"`
+	Filesize int64                   `json:"filesize"`
+	Content  []DirectDownloadContent `json:"content"`
+}
+
+type DirectDownloadContent struct {
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	Link       string `json:"link"`
+	StreamLink string `json:"stream_link"`
+}
+
+type HashCheck struct {
+	Status  string                `json:"status"`
+	Message string                `json:"message"`
+	Hashes  map[string]HashStatus `json:"hashes"`
+}
+
+type HashStatus struct {
 	Status string `json:"status"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+}
+
+// AccountInfo describes the premium account. PremiumUntil is a unix timestamp,
+// zero without premium, LimitUsed the used share of the fair use points (1 is all of them) and
+// SpaceUsed the cloud storage in bytes.
+type AccountInfo struct {
+	Status       string      `json:"status"`
+	Message      string      `json:"message"`
+	CustomerID   json.Number `json:"customer_id"`
+	PremiumUntil int64       `json:"premium_until"`
+	LimitUsed    float64     `json:"limit_used"`
+	SpaceUsed    float64     `json:"space_used"`
+}
+
+// UnmarshalJSON accepts the false premium_until premiumize sends for accounts
+// without premium.
+func (info *AccountInfo) UnmarshalJSON(data []byte) error {
+	type accountInfo AccountInfo
+	var raw struct {
+		accountInfo
+		PremiumUntil json.RawMessage `json:"premium_until"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*info = AccountInfo(raw.accountInfo)
+	info.PremiumUntil = 0
+	switch string(raw.PremiumUntil) {
+	case "", "null", "false":
+		return nil
+	}
+	return json.Unmarshal(raw.PremiumUntil, &info.PremiumUntil)
 }
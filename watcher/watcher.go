@@ -1,69 +1,505 @@
 package watcher
 
 import (
+	"context"
+	"github.com/fsnotify/fsnotify"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"sync"
 	"time"
 )
 
+const defaultQuietPeriod = 5 * time.Second
+const minStableCheckInterval = 100 * time.Millisecond
+
+// Op describes what happened to a watched file.
+type Op int
+
+const (
+	// Created is emitted once a new file is stable.
+	Created Op = iota + 1
+	// Modified is emitted once an emitted file changed and is stable again.
+	Modified
+	// Removed is emitted when an emitted file disappears.
+	Removed
+)
+
+func (op Op) String() string {
+	switch op {
+	case Created:
+		return "created"
+	case Modified:
+		return "modified"
+	case Removed:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// Event is emitted for every matching file that is created, modified or
+// removed. Info holds the last known state of the file, also for removals.
+type Event struct {
+	Path string
+	Op   Op
+	Info os.FileInfo
+}
+
 type FileWatcher struct {
-	config       FileWatcherConfig
-	watcher      []chan<- string
-	watcherMutex sync.RWMutex
-	running      bool
-	runningMutex sync.Mutex
+	config        FileWatcherConfig
+	baseDirs      []string
+	match         *regexp.Regexp
+	exclude       []*regexp.Regexp
+	watcher       []chan<- Event
+	errorWatcher  []chan<- error
+	watcherMutex  sync.RWMutex
+	running       bool
+	cancel        context.CancelFunc
+	done          chan struct{}
+	runningMutex  sync.Mutex
+	files         map[string]*fileState
+	filesMutex    sync.Mutex
+	eventsWatcher *fsnotify.Watcher
 }
 
 type FileWatcherConfig struct {
-	BaseDir      string
+	// BaseDir is watched in addition to BaseDirs.
+	BaseDir  string
+	BaseDirs []string
+	// MatchPattern is a regular expression a file path has to match to be
+	// watched.
 	MatchPattern string
+	// Exclude holds regular expressions for paths to ignore. Directories
+	// matching one of them are not descended into.
+	Exclude []string
+	// MaxDepth limits how deep files below a base directory are watched, 1
+	// only watches files directly inside of it. Zero means unlimited.
+	MaxDepth     int
 	ScanInterval time.Duration
+	// Polling scans the base directories every ScanInterval instead of
+	// relying on file system events. Required for file systems without
+	// inotify support, like NFS or SMB mounts. Polling is also used if events
+	// are not available.
+	Polling bool
+	// QuietPeriod is how long the size and modification time of a file have
+	// to stay unchanged before it is emitted.
+	QuietPeriod time.Duration
+}
+
+// fileState tracks a matching file until it is stable and has been emitted.
+// A file is created once, it is only created again after it has been
+// removed and created anew.
+type fileState struct {
+	info    os.FileInfo
+	changed time.Time
+	emitted bool
+	dirty   bool
 }
 
 func New(config FileWatcherConfig) *FileWatcher {
+	if config.QuietPeriod == 0 {
+		config.QuietPeriod = defaultQuietPeriod
+	}
+
+	var baseDirs []string
+	if config.BaseDir != "" {
+		baseDirs = append(baseDirs, filepath.Clean(config.BaseDir))
+	}
+	for _, dir := range config.BaseDirs {
+		baseDirs = append(baseDirs, filepath.Clean(dir))
+	}
+
+	var exclude []*regexp.Regexp
+	for _, pattern := range config.Exclude {
+		exclude = append(exclude, regexp.MustCompile(pattern))
+	}
+
 	return &FileWatcher{
-		config: config,
+		config:   config,
+		baseDirs: baseDirs,
+		match:    regexp.MustCompile(config.MatchPattern),
+		exclude:  exclude,
+		files:    make(map[string]*fileState),
 	}
 }
 
-func (w *FileWatcher) AddWatcher(watcher chan<- string) {
+func (w *FileWatcher) AddWatcher(watcher chan<- Event) {
 	w.watcherMutex.Lock()
 	w.watcher = append(w.watcher, watcher)
 	w.watcherMutex.Unlock()
 }
 
-func (w *FileWatcher) Run() {
+// AddErrorWatcher registers a channel for errors encountered while watching,
+// like unreadable directories. Errors are dropped if nobody listens.
+func (w *FileWatcher) AddErrorWatcher(watcher chan<- error) {
+	w.watcherMutex.Lock()
+	w.errorWatcher = append(w.errorWatcher, watcher)
+	w.watcherMutex.Unlock()
+}
+
+// Run starts watching in the background until the context is done or Stop
+// is called.
+func (w *FileWatcher) Run(ctx context.Context) {
 	w.runningMutex.Lock()
+	defer w.runningMutex.Unlock()
+
 	if w.running {
-		w.runningMutex.Unlock()
 		return
 	}
 	w.running = true
-	w.runningMutex.Unlock()
 
-	go w.watchDirectory()
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.done = make(chan struct{})
+
+	go func() {
+		defer close(w.done)
+
+		if !w.config.Polling {
+			err := w.startEvents(ctx)
+			if err == nil {
+				w.watchEvents(ctx)
+				return
+			}
+			w.emitError(ctx, err)
+		}
+
+		w.watchDirectories(ctx)
+	}()
+}
+
+// Stop ends watching and waits until no more events are emitted.
+func (w *FileWatcher) Stop() {
+	w.runningMutex.Lock()
+	defer w.runningMutex.Unlock()
+
+	if !w.running {
+		return
+	}
+
+	w.cancel()
+	<-w.done
+	w.running = false
 }
 
-func (w *FileWatcher) watchDirectory() {
-	match := regexp.MustCompile(w.config.MatchPattern)
+func (w *FileWatcher) watchDirectories(ctx context.Context) {
 	for {
-		filepath.Walk(w.config.BaseDir, func(path string, info os.FileInfo, err error) error {
-			if info.IsDir() {
-				return nil
+		seen := make(map[string]bool)
+		for _, dir := range w.baseDirs {
+			w.scan(ctx, dir, seen)
+		}
+		w.removeMissing(ctx, seen)
+		w.emitStable(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.config.ScanInterval):
+		}
+	}
+}
+
+// scan observes all matching files below the given directory and adds their
+// paths to seen.
+func (w *FileWatcher) scan(ctx context.Context, directory string, seen map[string]bool) {
+	filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			w.emitError(ctx, err)
+			return nil
+		}
+
+		if info.IsDir() {
+			if !w.includeDir(path) {
+				return filepath.SkipDir
 			}
+			return nil
+		}
+
+		if w.includeFile(path) {
+			w.observe(path, info)
+			seen[path] = true
+		}
+
+		return nil
+	})
+}
+
+func (w *FileWatcher) includeFile(path string) bool {
+	if !w.match.MatchString(path) || w.excluded(path) {
+		return false
+	}
+
+	depth, ok := w.depth(path)
+	return ok && (w.config.MaxDepth == 0 || depth <= w.config.MaxDepth)
+}
+
+func (w *FileWatcher) includeDir(path string) bool {
+	if w.excluded(path) {
+		return false
+	}
+
+	depth, ok := w.depth(path)
+	return ok && (w.config.MaxDepth == 0 || depth < w.config.MaxDepth)
+}
+
+func (w *FileWatcher) excluded(path string) bool {
+	for _, exclude := range w.exclude {
+		if exclude.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// depth returns how many levels below its base directory a path is.
+func (w *FileWatcher) depth(path string) (int, bool) {
+	for _, dir := range w.baseDirs {
+		relative, err := filepath.Rel(dir, path)
+		if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(os.PathSeparator)) {
+			continue
+		}
+		if relative == "." {
+			return 0, true
+		}
+		return strings.Count(relative, string(os.PathSeparator)) + 1, true
+	}
+	return 0, false
+}
+
+// observe records the current state of a file. A file replacing a tracked
+// one is treated as a new file.
+func (w *FileWatcher) observe(path string, info os.FileInfo) {
+	w.filesMutex.Lock()
+	defer w.filesMutex.Unlock()
+
+	state, ok := w.files[path]
+	if !ok || !os.SameFile(state.info, info) {
+		w.files[path] = &fileState{info: info, changed: time.Now()}
+		return
+	}
+
+	if info.Size() != state.info.Size() || !info.ModTime().Equal(state.info.ModTime()) {
+		state.info = info
+		state.changed = time.Now()
+		state.dirty = state.emitted
+	}
+}
+
+// remove drops the state of a removed file or of all files below a removed
+// directory, so they are created again when they reappear.
+func (w *FileWatcher) remove(ctx context.Context, path string) {
+	var removed []Event
 
-			if match.MatchString(path) {
-				w.watcherMutex.RLock()
-				for _, watcher := range w.watcher {
-					watcher <- path
-				}
-				w.watcherMutex.RUnlock()
+	w.filesMutex.Lock()
+	prefix := path + string(os.PathSeparator)
+	for file, state := range w.files {
+		if file == path || strings.HasPrefix(file, prefix) {
+			if state.emitted {
+				removed = append(removed, Event{Path: file, Op: Removed, Info: state.info})
 			}
+			delete(w.files, file)
+		}
+	}
+	w.filesMutex.Unlock()
 
-			return nil
-		})
-		time.Sleep(w.config.ScanInterval)
+	for _, event := range removed {
+		w.emit(ctx, event)
 	}
 }
+
+func (w *FileWatcher) removeMissing(ctx context.Context, seen map[string]bool) {
+	var missing []string
+
+	w.filesMutex.Lock()
+	for file := range w.files {
+		if !seen[file] {
+			missing = append(missing, file)
+		}
+	}
+	w.filesMutex.Unlock()
+
+	for _, file := range missing {
+		w.remove(ctx, file)
+	}
+}
+
+// emitStable emits all files that are new or have been modified since they
+// were emitted and did not change within the quiet period.
+func (w *FileWatcher) emitStable(ctx context.Context) {
+	var stable []Event
+
+	w.filesMutex.Lock()
+	for path, state := range w.files {
+		if time.Since(state.changed) < w.config.QuietPeriod {
+			continue
+		}
+
+		if !state.emitted {
+			stable = append(stable, Event{Path: path, Op: Created, Info: state.info})
+		} else if state.dirty {
+			stable = append(stable, Event{Path: path, Op: Modified, Info: state.info})
+		}
+		state.emitted = true
+		state.dirty = false
+	}
+	w.filesMutex.Unlock()
+
+	for _, event := range stable {
+		w.emit(ctx, event)
+	}
+}
+
+// refreshPending stats all files with changes that have not been emitted yet,
+// catching writes that did not produce an event.
+func (w *FileWatcher) refreshPending(ctx context.Context) {
+	var pending []string
+
+	w.filesMutex.Lock()
+	for path, state := range w.files {
+		if !state.emitted || state.dirty {
+			pending = append(pending, path)
+		}
+	}
+	w.filesMutex.Unlock()
+
+	for _, path := range pending {
+		if info, err := os.Stat(path); err == nil {
+			w.observe(path, info)
+		} else if os.IsNotExist(err) {
+			w.remove(ctx, path)
+		}
+	}
+}
+
+func (w *FileWatcher) emit(ctx context.Context, event Event) {
+	w.watcherMutex.RLock()
+	defer w.watcherMutex.RUnlock()
+
+	for _, watcher := range w.watcher {
+		select {
+		case watcher <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *FileWatcher) emitError(ctx context.Context, err error) {
+	w.watcherMutex.RLock()
+	defer w.watcherMutex.RUnlock()
+
+	for _, watcher := range w.errorWatcher {
+		select {
+		case watcher <- err:
+		case <-ctx.Done():
+			return
+		default:
+		}
+	}
+}
+
+func (w *FileWatcher) startEvents(ctx context.Context) error {
+	events, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range w.baseDirs {
+		if err := events.Add(dir); err != nil {
+			events.Close()
+			return err
+		}
+	}
+
+	w.eventsWatcher = events
+	for _, dir := range w.baseDirs {
+		w.addRecursive(ctx, dir)
+	}
+	return nil
+}
+
+func (w *FileWatcher) watchEvents(ctx context.Context) {
+	events := w.eventsWatcher
+	defer events.Close()
+
+	checkInterval := w.config.QuietPeriod / 2
+	if checkInterval < minStableCheckInterval {
+		checkInterval = minStableCheckInterval
+	}
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	// Files which existed before the watcher was started
+	for _, dir := range w.baseDirs {
+		w.scan(ctx, dir, make(map[string]bool))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events.Events:
+			w.handleEvent(ctx, event)
+		case err := <-events.Errors:
+			w.emitError(ctx, err)
+			// Events may have been lost, catch up with a full scan
+			seen := make(map[string]bool)
+			for _, dir := range w.baseDirs {
+				w.scan(ctx, dir, seen)
+			}
+			w.removeMissing(ctx, seen)
+		case <-ticker.C:
+			w.refreshPending(ctx)
+			w.emitStable(ctx)
+		}
+	}
+}
+
+func (w *FileWatcher) handleEvent(ctx context.Context, event fsnotify.Event) {
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		w.remove(ctx, event.Name)
+		return
+	}
+
+	info, err := os.Stat(event.Name)
+	if err != nil {
+		return
+	}
+
+	if info.IsDir() {
+		if event.Op&fsnotify.Create != 0 && w.includeDir(event.Name) {
+			w.addRecursive(ctx, event.Name)
+			// Files may have been created before the directory was watched
+			w.scan(ctx, event.Name, make(map[string]bool))
+		}
+		return
+	}
+
+	if w.includeFile(event.Name) {
+		w.observe(event.Name, info)
+	}
+}
+
+// addRecursive adds event watches for a directory and all included
+// directories below it.
+func (w *FileWatcher) addRecursive(ctx context.Context, directory string) {
+	filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			w.emitError(ctx, err)
+			return nil
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		if !w.includeDir(path) {
+			return filepath.SkipDir
+		}
+
+		if err := w.eventsWatcher.Add(path); err != nil {
+			w.emitError(ctx, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,285 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestEmitStable(t *testing.T) {
+	const quietPeriod = time.Minute
+
+	tests := []struct {
+		description string
+		age         time.Duration
+		emitted     bool
+		dirty       bool
+		want        []Op
+	}{
+		{description: "new file within the quiet period", age: quietPeriod / 2},
+		{description: "new file after the quiet period", age: quietPeriod, want: []Op{Created}},
+		{description: "emitted file unchanged", age: 2 * quietPeriod, emitted: true},
+		{description: "emitted file modified within the quiet period", age: quietPeriod / 2, emitted: true, dirty: true},
+		{description: "emitted file modified after the quiet period", age: quietPeriod, emitted: true, dirty: true, want: []Op{Modified}},
+	}
+
+	for _, test := range tests {
+		directory := tempDir(t)
+		path := writeFile(t, directory, "a.torrent", "content")
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		events := make(chan Event, 10)
+		w := New(FileWatcherConfig{BaseDir: directory, MatchPattern: `\.torrent$`, QuietPeriod: quietPeriod})
+		w.AddWatcher(events)
+		w.files[path] = &fileState{info: info, changed: time.Now().Add(-test.age), emitted: test.emitted, dirty: test.dirty}
+
+		// Every change is emitted once only.
+		w.emitStable(context.Background())
+		w.emitStable(context.Background())
+
+		if got := receivedOps(events); !equalOps(got, test.want) {
+			t.Errorf("%s: emitted %v, want %v", test.description, got, test.want)
+		}
+		os.RemoveAll(directory)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	directory := tempDir(t)
+	defer os.RemoveAll(directory)
+
+	w := New(FileWatcherConfig{BaseDir: directory, MatchPattern: `\.torrent$`})
+	path := writeFile(t, directory, "a.torrent", "content")
+	observeFile(t, w, path)
+	state := w.files[path]
+	if state.emitted || state.dirty {
+		t.Fatalf("new file state %+v, want neither emitted nor dirty", state)
+	}
+
+	// Unchanged files keep their state.
+	state.emitted = true
+	changed := state.changed.Add(-time.Hour)
+	state.changed = changed
+	observeFile(t, w, path)
+	if w.files[path] != state || state.dirty || !state.changed.Equal(changed) {
+		t.Fatalf("unchanged file state %+v, want it untouched", state)
+	}
+
+	// Growing files are dirty again and their quiet period restarts.
+	writeFile(t, directory, "a.torrent", "more content")
+	observeFile(t, w, path)
+	if w.files[path] != state || !state.dirty || !state.changed.After(changed) {
+		t.Fatalf("modified file state %+v, want dirty and changed", state)
+	}
+
+	// Replaced files are new files. The old file is kept, so the new one
+	// gets a different inode.
+	if err := os.Rename(path, path+".old"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, directory, "a.torrent", "replaced")
+	observeFile(t, w, path)
+	if w.files[path] == state || w.files[path].emitted {
+		t.Fatalf("replaced file state %+v, want a new state", w.files[path])
+	}
+}
+
+func TestRunWaitsForQuietPeriod(t *testing.T) {
+	for _, polling := range []bool{true, false} {
+		directory := tempDir(t)
+		const quietPeriod = 300 * time.Millisecond
+
+		events := make(chan Event, 10)
+		w := New(FileWatcherConfig{
+			BaseDir:      directory,
+			MatchPattern: `\.torrent$`,
+			ScanInterval: 20 * time.Millisecond,
+			Polling:      polling,
+			QuietPeriod:  quietPeriod,
+		})
+		w.AddWatcher(events)
+		w.Run(context.Background())
+
+		written := time.Now()
+		path := writeFile(t, directory, "a.torrent", "content")
+		event := nextEvent(t, events)
+		if event.Op != Created || event.Path != path {
+			t.Errorf("polling %v: got %s %s, want created %s", polling, event.Op, event.Path, path)
+		}
+		if elapsed := time.Since(written); elapsed < quietPeriod {
+			t.Errorf("polling %v: created after %s, before the quiet period of %s", polling, elapsed, quietPeriod)
+		}
+
+		writeFile(t, directory, "a.torrent", "more content")
+		if event := nextEvent(t, events); event.Op != Modified || event.Path != path {
+			t.Errorf("polling %v: got %s %s, want modified %s", polling, event.Op, event.Path, path)
+		}
+
+		os.Remove(path)
+		if event := nextEvent(t, events); event.Op != Removed || event.Path != path {
+			t.Errorf("polling %v: got %s %s, want removed %s", polling, event.Op, event.Path, path)
+		}
+
+		w.Stop()
+		os.RemoveAll(directory)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	directory, err := ioutil.TempDir("", "pget-watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return directory
+}
+
+func writeFile(t *testing.T, directory string, name string, content string) string {
+	path := filepath.Join(directory, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func observeFile(t *testing.T, w *FileWatcher, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.observe(path, info)
+}
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event within 5s")
+	}
+	return Event{}
+}
+
+func receivedOps(events chan Event) []Op {
+	var ops []Op
+	for {
+		select {
+		case event := <-events:
+			ops = append(ops, event.Op)
+		default:
+			return ops
+		}
+	}
+}
+
+func equalOps(a []Op, b []Op) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestInclude(t *testing.T) {
+	config := FileWatcherConfig{
+		BaseDirs:     []string{"/upload", "/more/"},
+		MatchPattern: `\.torrent$`,
+		Exclude:      []string{`/pending(/|$)`},
+	}
+
+	tests := []struct {
+		path     string
+		maxDepth int
+		file     bool
+		dir      bool
+	}{
+		{path: "/upload", file: false, dir: true},
+		{path: "/upload/a.torrent", file: true, dir: true},
+		{path: "/upload/a.torrent", maxDepth: 1, file: true, dir: false},
+		{path: "/upload/tv/a.torrent", file: true, dir: true},
+		{path: "/upload/tv/a.torrent", maxDepth: 1, file: false, dir: false},
+		{path: "/upload/tv/a.torrent", maxDepth: 2, file: true, dir: false},
+		{path: "/upload/tv", maxDepth: 1, file: false, dir: false},
+		{path: "/upload/tv", maxDepth: 2, file: false, dir: true},
+		{path: "/upload/tv/shows/deep/a.torrent", file: true, dir: true},
+		{path: "/upload/a.txt", file: false, dir: true},
+		{path: "/upload/..tv/a.torrent", file: true, dir: true},
+		{path: "/upload/pending", file: false, dir: false},
+		{path: "/upload/pending/a.torrent", file: false, dir: false},
+		{path: "/upload/tv/pending/a.torrent", file: false, dir: false},
+		{path: "/upload/pendingtv/a.torrent", file: true, dir: true},
+		{path: "/more/a.torrent", maxDepth: 1, file: true, dir: false},
+		{path: "/other/a.torrent", file: false, dir: false},
+		{path: "/uploads/a.torrent", file: false, dir: false},
+		{path: "/a.torrent", file: false, dir: false},
+	}
+
+	for _, test := range tests {
+		config.MaxDepth = test.maxDepth
+		w := New(config)
+		path := filepath.FromSlash(test.path)
+		if got := w.includeFile(path); got != test.file {
+			t.Errorf("includeFile(%q) with max depth %d = %v, want %v", test.path, test.maxDepth, got, test.file)
+		}
+		if got := w.includeDir(path); got != test.dir {
+			t.Errorf("includeDir(%q) with max depth %d = %v, want %v", test.path, test.maxDepth, got, test.dir)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	directory := tempDir(t)
+	defer os.RemoveAll(directory)
+
+	for _, name := range []string{"a.torrent", "b.txt", "tv/c.torrent", "tv/shows/d.torrent", "pending/e.torrent", "tv/pending/f.torrent"} {
+		writeFile(t, directory, name, "content")
+	}
+
+	tests := []struct {
+		maxDepth int
+		want     []string
+	}{
+		{maxDepth: 0, want: []string{"a.torrent", "tv/c.torrent", "tv/shows/d.torrent"}},
+		{maxDepth: 1, want: []string{"a.torrent"}},
+		{maxDepth: 2, want: []string{"a.torrent", "tv/c.torrent"}},
+	}
+
+	for _, test := range tests {
+		w := New(FileWatcherConfig{
+			BaseDir:      directory,
+			MatchPattern: `\.torrent$`,
+			Exclude:      []string{`/pending(/|$)`},
+			MaxDepth:     test.maxDepth,
+		})
+
+		seen := make(map[string]bool)
+		w.scan(context.Background(), directory, seen)
+
+		var got []string
+		for path := range seen {
+			relative, _ := filepath.Rel(directory, path)
+			got = append(got, filepath.ToSlash(relative))
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("scan with max depth %d found %v, want %v", test.maxDepth, got, test.want)
+		}
+		if len(w.files) != len(test.want) {
+			t.Errorf("scan with max depth %d tracks %d files, want %d", test.maxDepth, len(w.files), len(test.want))
+		}
+	}
+}